@@ -4,6 +4,8 @@ go 1.18
 
 require (
 	github.com/dustin/go-humanize v1.0.0
+	github.com/google/uuid v1.1.1
+	github.com/gorilla/websocket v1.4.0
 	github.com/hirochachacha/go-smb2 v1.1.0
 	github.com/jrudio/go-plex-client v0.0.0-20220428052413-e5b4386beb17
 	github.com/u2takey/ffmpeg-go v0.4.1
@@ -15,8 +17,6 @@ require (
 	github.com/cpuguy83/go-md2man/v2 v2.0.1 // indirect
 	github.com/geoffgarside/ber v1.1.0 // indirect
 	github.com/go-ole/go-ole v1.2.6 // indirect
-	github.com/google/uuid v1.1.1 // indirect
-	github.com/gorilla/websocket v1.4.0 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
 	github.com/u2takey/go-utils v0.3.1 // indirect
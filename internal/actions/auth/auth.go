@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"github.com/urfave/cli/v2"
+	"plex-go-sync/internal/logger"
+	"plex-go-sync/internal/models"
+	"plex-go-sync/internal/plex"
+	"time"
+)
+
+const pollInterval = 2 * time.Second
+
+// FromContext runs the plex.tv PIN link flow and stores the resulting token
+func FromContext(c *cli.Context) error {
+	logger.SetLogLevel(c.String("loglevel"))
+
+	target := c.Path("config")
+	if c.Path("credentials") != "" {
+		target = c.Path("credentials")
+	}
+	identifier, err := models.ClientIdentifier(target)
+	if err != nil {
+		logger.LogError("Could not read ", target, ": ", err.Error())
+		return err
+	}
+
+	ctx := c.Context
+	client := plex.NewPinClient(c.String("plex-tv-url"), identifier)
+	pin, err := client.RequestPin(&ctx)
+	if err != nil {
+		logger.LogError("Could not request a pin: ", err.Error())
+		return err
+	}
+
+	linkURL := c.String("link-url")
+	if linkURL == "" {
+		linkURL = plex.DefaultLinkURL
+	}
+	logger.LogInfo("Open", linkURL, "and enter the code", logger.Green+pin.Code+logger.Reset)
+	logger.LogInfo("Waiting for authorization...")
+
+	token, err := client.WaitForToken(&ctx, pin, pollInterval)
+	if err != nil {
+		logger.LogError("Authorization failed: ", err.Error())
+		return err
+	}
+
+	if err := models.WriteToken(target, c.String("connection"), token, identifier); err != nil {
+		logger.LogError("Could not save token: ", err.Error())
+		return err
+	}
+
+	logger.LogInfo(logger.Green+"Token saved to", target+logger.Reset)
+	if c.Path("credentials") != "" {
+		logger.LogInfof("Add \"credentials\": %q to %s to use it\n", target, c.Path("config"))
+	}
+	return nil
+}
@@ -11,6 +11,8 @@ import (
 	"plex-go-sync/internal/filesystem"
 	"plex-go-sync/internal/logger"
 	"plex-go-sync/internal/models"
+	"plex-go-sync/internal/plex"
+	"plex-go-sync/internal/selection"
 	. "plex-go-sync/internal/structures"
 	"strings"
 	"time"
@@ -24,6 +26,10 @@ func FromContext(c *cli.Context) error {
 		logger.LogError(err.Error())
 		return err
 	}
+	if err := plex.ResolveServers(config); err != nil {
+		logger.LogError(err.Error())
+		return err
+	}
 	ctx := context.WithValue(c.Context, "config", config)
 
 	mediaLibrary := filesystem.NewFileSystem(config.Destination)
@@ -58,25 +64,40 @@ func FromContext(c *cli.Context) error {
 	return nil
 }
 
+// Remover deletes a file or empty directory which clean rejected. The plan command passes one which only
+// records what would be deleted.
+type Remover func(dir filesystem.FileSystem, path string, size uint64, duration time.Duration)
+
 func FromPlaylist(ctx *context.Context, playlist *models.Playlist, fs filesystem.FileSystem) (map[string]uint64, int64, error) {
+	return Inspect(ctx, playlist, fs, removeItem)
+}
+
+// Inspect loads the items of the playlist and finds the files of each base on the destination, passing every
+// file which should not be kept to remove. It returns the size of each file kept, by item key.
+func Inspect(ctx *context.Context, playlist *models.Playlist, fs filesystem.FileSystem, remove Remover) (map[string]uint64, int64, error) {
 	var config = models.GetConfig(ctx)
-	items, err := GetPlaylistItems(ctx, playlist.Name)
+	items, err := GetPlaylistItems(ctx, playlist)
 	if err != nil {
 		return nil, 0, err
 	}
 
 	playlist.Items = *items
-	base := playlist.GetBase()
+	// a quota which isn't valid stops the playlist before anything is removed
+	usage, err := selection.NewUsage(playlist.Quotas)
+	if err != nil {
+		return nil, 0, err
+	}
+	playlist.Bases = nil
+	bases := playlist.GetBases()
 	itemsToKeep := playlist.Items.Copy()
 
 	// if we are cleaning the directory, we need to get all playlist items so that we don't delete anything that is
 	// still in one of the playlists
 	if playlist.Items.Len() > 0 && playlist.Clean {
-		var dir = playlist.GetBase() // get the base directory of the playlist
 		for _, altList := range config.Playlists {
 			if altList.Name != playlist.Name {
 				logger.LogInfo("Getting playlist items from", altList.Name, "so that we don't delete them")
-				_, err := PopulateMediaItems(ctx, altList.Name, dir, itemsToKeep)
+				_, err := PopulateMediaItems(ctx, altList.Name, bases, itemsToKeep)
 				if err != nil {
 					return nil, 0, err
 				}
@@ -89,20 +110,51 @@ func FromPlaylist(ctx *context.Context, playlist *models.Playlist, fs filesystem
 		itemsToKeep = nil
 	}
 
-	logger.LogInfo("Cleaning ", ospath.Join(fs.GetPath(), base), " directory")
-	existingFiles, existingSize, err := cleanFiles(ctx, fs, base, itemsToKeep)
-	if err == nil {
-		logger.LogInfo("Existing Size: ", humanize.Bytes(uint64(existingSize)))
+	// the sizes of every base are combined, so the playlist size limit covers all of them
+	existingFiles := make(map[string]uint64)
+	existingSize := int64(0)
+	for _, base := range bases {
+		logger.LogInfo("Cleaning ", ospath.Join(fs.GetPath(), base), " directory")
+		files, size, err := cleanFiles(ctx, fs, base, itemsToKeep, remove)
+		if err != nil {
+			return nil, 0, err
+		}
+		for key, fileSize := range files {
+			existingFiles[key] += fileSize
+		}
+		existingSize += size
+	}
+
+	// the copies are counted in order, so a show, genre or rating over its quota loses its lowest priority items
+	for _, item := range usage.Trim(&playlist.Items, existingFiles) {
+		existingSize -= trimItem(config, fs, item.Value, existingFiles, remove)
 	}
+	logger.LogInfo("Existing Size: ", humanize.Bytes(uint64(existingSize)))
 
-	return existingFiles, existingSize, err
+	return existingFiles, existingSize, nil
+}
+
+// trimItem removes the copy of an item which is over a quota, returning its size
+func trimItem(config *models.Config, fs filesystem.FileSystem, item models.PlaylistItem, existing map[string]uint64,
+	remove Remover) int64 {
+	removed := int64(0)
+	for _, path := range item.Paths {
+		key := plex.GetKey(path)
+		if size, ok := existing[key]; ok {
+			logger.LogVerbose("Removing", path, "because it is over a quota")
+			remove(fs, strings.TrimSuffix(path, ospath.Ext(path))+"."+config.GetExtension(item), size, item.Duration)
+			delete(existing, key)
+			removed += int64(size)
+		}
+	}
+	return removed
 }
 
 /**
  * Remove files which are not in a lookup map. If the lookup map is empty, nothing is removed.
  */
 func cleanFiles(ctx *context.Context, dir filesystem.FileSystem, base string,
-	lookup *OrderedMap[models.PlaylistItem]) (map[string]uint64, int64, error) {
+	lookup *OrderedMap[models.PlaylistItem], remove Remover) (map[string]uint64, int64, error) {
 
 	totalSize := int64(0)
 	var config = models.GetConfig(ctx)
@@ -137,15 +189,16 @@ func cleanFiles(ctx *context.Context, dir filesystem.FileSystem, base string,
 			size = uint64(fi.Size())
 		}
 
-		// If the file is a mp4, we need to calculate a valid key to compare against.
+		// If the file is a mp4 or music track, we need to calculate a valid key to compare against.
 		// the key is the path to the file, without the base directory, extension, or a leading slash
 		split := strings.Split(key, ".")
+		audio := len(split) > 1 && split[len(split)-1] == config.AudioFormat.Extension()
 
-		if len(split) > 1 && split[len(split)-1] == config.MediaFormat.Format {
+		if len(split) > 1 && (split[len(split)-1] == config.MediaFormat.Format || audio) {
 			key = strings.Join(split[:len(split)-1], ".")
 		} else {
 			logger.LogVerbose("Skipping file with extension", split[len(split)-1])
-			removeItem(dir, path, size, 0)
+			remove(dir, path, size, 0)
 			return nil
 		}
 
@@ -156,7 +209,8 @@ func cleanFiles(ctx *context.Context, dir filesystem.FileSystem, base string,
 			item, ok = lookup.Get(key)
 			if !ok {
 				logger.LogVerbose("Removing file", key, "because it is not in the lookup map")
-				removeItem(dir, path, size, 0)
+				remove(dir, path, size, 0)
+				return nil
 			}
 		}
 
@@ -165,10 +219,16 @@ func cleanFiles(ctx *context.Context, dir filesystem.FileSystem, base string,
 		duration := time.Duration(0)
 		if err != nil {
 			logger.LogVerbose("Error reading file: ", path, err.Error())
-			removeItem(dir, path, size, duration)
+			remove(dir, path, size, duration)
 			return nil
 		}
-		ok, duration, _, err = ffmpeg.Probe(ctx, file, size)
+		if audio {
+			// tracks always end up in the configured audio format, so any readable track is kept
+			_, _, duration, err = ffmpeg.ProbeAudio(ctx, file)
+			ok = true
+		} else {
+			ok, duration, _, err = ffmpeg.Probe(ctx, file, size)
+		}
 		if err != nil {
 			logger.LogVerbose("Error parsing file: ", path, err.Error())
 			return nil
@@ -176,7 +236,7 @@ func cleanFiles(ctx *context.Context, dir filesystem.FileSystem, base string,
 
 		if !ok && !config.FastConvert {
 			logger.LogVerbose(" Existing file is incorrect format: ", path)
-			removeItem(dir, path, size, duration)
+			remove(dir, path, size, duration)
 			return nil
 		}
 
@@ -210,7 +270,7 @@ func cleanFiles(ctx *context.Context, dir filesystem.FileSystem, base string,
 			}
 		}
 
-		removeItem(dir, path, size, duration)
+		remove(dir, path, size, duration)
 		return nil
 	})
 	if err != nil {
@@ -220,10 +280,7 @@ func cleanFiles(ctx *context.Context, dir filesystem.FileSystem, base string,
 	// Now we need to remove any empty directories
 	for i := len(directories) - 1; i >= 0; i-- {
 		if dir.IsEmptyDir(directories[i]) {
-			logger.LogInfo("Removing empty directory: ", directories[i])
-			if err := dir.Remove(directories[i]); err != nil {
-				logger.LogInfo(err.Error())
-			}
+			remove(dir, directories[i], 0, 0)
 		}
 	}
 
@@ -2,81 +2,95 @@ package clean
 
 import (
 	"context"
+	"errors"
 	client "github.com/jrudio/go-plex-client"
-	"golang.org/x/exp/maps"
 	"math/rand"
 	"plex-go-sync/internal/logger"
 	"plex-go-sync/internal/models"
 	"plex-go-sync/internal/plex"
+	"plex-go-sync/internal/selection"
 	. "plex-go-sync/internal/structures"
 	"time"
 )
 
-func GetPlaylistItems(ctx *context.Context, name string) (*OrderedMap[models.PlaylistItem], error) {
-	re := NewOrderedMap[models.PlaylistItem]()
-
-	metadata, err := PopulateMediaItems(ctx, name, "", &re)
+// GetPlaylistItems returns the items of a playlist in the order they should be copied, as set by the order and
+// seed of the playlist
+func GetPlaylistItems(ctx *context.Context, playlist *models.Playlist) (*OrderedMap[models.PlaylistItem], error) {
+	strategy, err := selection.Get(playlist.Order)
 	if err != nil {
 		return nil, err
 	}
 
-	rand.Seed(time.Now().UnixNano())
-	re.Shuffle()
+	items := NewOrderedMap[models.PlaylistItem]()
+	if _, err := PopulateMediaItems(ctx, playlist.Name, nil, &items); err != nil {
+		return nil, err
+	}
 
-	if metadata[0].Type != "episode" {
-		logger.LogInfo("Playlist ", name, " retrieved, ", re.Len(), " items")
-		return &re, err
+	if selection.NeedsGenres(playlist.Quotas) {
+		if err := addGenres(ctx, &items); err != nil {
+			return nil, err
+		}
 	}
 
-	distinctParents := make(map[string]bool)
-	for _, item := range metadata {
-		distinctParents[item.GrandparentTitle] = true
+	seed := playlist.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	order := playlist.Order
+	if order == "" {
+		order = selection.OrderRandom
 	}
-	parents := maps.Keys(distinctParents)
+	logger.LogInfo("Ordering playlist", playlist.Name, "by", order, "with seed", seed)
+	env := &selection.Env{Playlist: playlist, Random: rand.New(rand.NewSource(seed)), History: selection.LoadHistory(models.GetConfig(ctx))}
+	ordered := selection.Apply(&items, strategy, env)
 
-	i := -1
-	// if we are dealing with a tv show playlist, we will sort the shuffled results to ensure we evenly pick from each show in the list.
-itemLoop:
-	for item := re.Front(); item != nil; item = item.Next() {
-		i = i + 1
-		if i >= len(parents) {
-			i = 0
-		}
-		if parents[i] == item.Value.Parent {
-			continue
-		}
+	logger.LogInfo("Playlist ", playlist.Name, " retrieved, ", ordered.Len(), " items")
+	return &ordered, nil
+}
+
+// addGenres looks up the genres of each item, which are set on the show or artist for episodes and tracks
+func addGenres(ctx *context.Context, items *OrderedMap[models.PlaylistItem]) error {
+	config := models.GetConfig(ctx)
+	plexServer, err := plex.Connect(config.SourceConnection)
+	if err != nil {
+		return err
+	}
 
-		for s := item.Next(); s != nil; s = s.Next() {
-			if parents[i] == s.Value.Parent {
-				re.Swap(s, item)
-				item = s
-				continue itemLoop
+	genres := make(map[string][]string)
+	for item := items.Front(); item != nil; item = item.Next() {
+		key := item.Value.ParentKey
+		if key == "" {
+			key = item.Value.RatingKey
+		}
+		if _, ok := genres[key]; !ok {
+			if genres[key], err = plexServer.GetGenres(ctx, key); err != nil {
+				logger.LogWarning("Error getting the genres of", item.Value.Paths[0], ":", err.Error())
 			}
 		}
-
-		parents = append(parents[:i], parents[i+1:]...)
-		i = i - 1
+		item.Value.Genres = genres[key]
 	}
-
-	logger.LogVerbose("Playlist ", name, " retrieved, ", re.Len(), " items")
-	return &re, err
+	return nil
 }
 
-func PopulateMediaItems(ctx *context.Context, name string, baseDir string, itemMap *OrderedMap[models.PlaylistItem]) ([]client.Metadata, error) {
+// PopulateMediaItems adds the items of a playlist to itemMap. When bases is set, only media in one of those base
+// directories is considered.
+func PopulateMediaItems(ctx *context.Context, name string, bases []string, itemMap *OrderedMap[models.PlaylistItem]) ([]client.Metadata, error) {
 	config := models.GetConfig(ctx)
-	plexServer, err := plex.New(config.Server, config.Token)
+	plexServer, err := plex.Connect(config.SourceConnection)
 	if err != nil {
 		logger.LogError("Failed to connect to plex: ", err)
 		return nil, err
 	}
 
-	items, err := plexServer.GetPlaylistItems(name)
+	items, err := plexServer.IteratePlaylistItems(ctx, name)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, item := range items.MediaContainer.Metadata {
-		var mediaPaths, duration = plex.GetMediaPath(ctx, item, baseDir)
+	var metadata []client.Metadata
+	for items.Next() {
+		item := items.Item()
+		var mediaPaths, parts, duration = plex.GetMediaPath(ctx, item, bases)
 
 		if len(mediaPaths) == 0 {
 			continue
@@ -86,8 +100,31 @@ func PopulateMediaItems(ctx *context.Context, name string, baseDir string, itemM
 			keys[i] = plex.GetKey(path)
 		}
 
-		newItem := models.PlaylistItem{Paths: mediaPaths, Parent: item.GrandparentTitle, Duration: duration}
+		viewCount, _ := item.ViewCount.Int64()
+		newItem := models.PlaylistItem{Paths: mediaPaths, Parts: parts, Type: item.Type, Parent: item.GrandparentTitle,
+			ParentKey: item.GrandparentRatingKey, Duration: duration, RatingKey: item.RatingKey,
+			Season: int(item.ParentIndex), Episode: int(item.Index), ViewCount: int(viewCount), AddedAt: item.AddedAt,
+			LastViewedAt: item.LastViewedAt, Rating: item.Rating, ContentRating: item.ContentRating}
+		if media, ok := plex.GetMedia(ctx, item, mediaPaths[0]); ok {
+			for _, part := range media.Part {
+				newItem.Size += int64(part.Size)
+			}
+			newItem.Bitrate = media.Bitrate
+			newItem.Height = media.Height
+			newItem.Container = media.Container
+			newItem.Codec = media.AudioCodec
+		}
 		itemMap.SetAll(keys, newItem)
+
+		// the media details are only needed for the item map, so drop them to keep memory down
+		item.Media = nil
+		metadata = append(metadata, item)
+	}
+	if err := items.Err(); err != nil {
+		return nil, err
+	}
+	if len(metadata) == 0 {
+		return nil, errors.New("playlist " + name + " has no items")
 	}
-	return items.MediaContainer.Metadata, nil
+	return metadata, nil
 }
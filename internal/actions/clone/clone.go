@@ -9,6 +9,8 @@ import (
 	. "plex-go-sync/internal/filesystem"
 	"plex-go-sync/internal/logger"
 	"plex-go-sync/internal/models"
+	"plex-go-sync/internal/plex"
+	"plex-go-sync/internal/selection"
 	. "plex-go-sync/internal/structures"
 	"time"
 )
@@ -20,6 +22,10 @@ func FromContext(c *cli.Context) error {
 		logger.LogError(err.Error())
 		return err
 	}
+	if err := plex.ResolveServers(config); err != nil {
+		logger.LogError(err.Error())
+		return err
+	}
 	ctx := context.WithValue(c.Context, "config", config)
 
 	dest := NewFileSystem(config.Destination)
@@ -33,6 +39,25 @@ func FromContext(c *cli.Context) error {
 		config.Playlists = playlists
 	}
 
+	scans := newScanBatcher(&ctx, config)
+	history := selection.LoadHistory(config)
+	sizes := selection.LoadSizeHistory(config)
+	allocator := models.NewAllocator(dest, config.Playlists)
+
+	var rotated map[string]bool
+	if config.Rotate {
+		removed, keys, err := sync.Rotate(&ctx, dest)
+		if err != nil {
+			logger.LogWarning("Skipping rotation: ", err.Error())
+		}
+		rotated = keys
+		for _, file := range removed {
+			if scans != nil {
+				scans.Add(file)
+			}
+		}
+	}
+
 	logger.LogInfo("Playlists to copy: ", len(config.Playlists))
 	go WatchProgress(progress, &config.Playlists)
 
@@ -43,7 +68,7 @@ func FromContext(c *cli.Context) error {
 		select {
 		case <-wg.WaitFor(c.Int("threads")):
 			go func() {
-				FromPlaylist(&ctx, playlist, src, dest, progress)
+				FromPlaylist(&ctx, playlist, src, dest, scans, history, sizes, allocator, rotated, progress)
 				wg.Done()
 			}()
 		case <-c.Done():
@@ -58,8 +83,16 @@ func FromContext(c *cli.Context) error {
 	close(progress)
 	ClearProgress()
 	CloseAllSmbConnections()
+	logger.LogInfo(sizes.Summary())
+	if scans != nil {
+		scans.Flush()
+	}
 	if !models.IsDone(&c.Context) {
-		err = sync.SyncLibraries(&ctx)
+		// the copied files are already being scanned, so a full refresh of every library isn't needed
+		err = sync.SyncLibraries(&ctx, scans == nil || !scans.Sent())
+	}
+	if err == nil && config.RecreatePlaylists && !models.IsDone(&c.Context) {
+		err = sync.RecreatePlaylists(&ctx)
 	}
 	if err != nil {
 		logger.LogError(err.Error())
@@ -67,8 +100,12 @@ func FromContext(c *cli.Context) error {
 	return nil
 }
 
-func FromPlaylist(ctx *context.Context, playlist *models.Playlist, src FileSystem, dest FileSystem, progress chan<- *models.Playlist) {
+func FromPlaylist(ctx *context.Context, playlist *models.Playlist, src FileSystem, dest FileSystem, scans *plex.ScanBatcher,
+	history *selection.History, sizes *selection.SizeHistory, allocator *models.Allocator, rotated map[string]bool,
+	progress chan<- *models.Playlist) {
 	var config = models.GetConfig(ctx)
+	// a skipped playlist gives back the size it reserved too
+	defer allocator.Release(playlist)
 
 	existingFiles, existingSize, err := clean.FromPlaylist(ctx, playlist, dest)
 
@@ -78,16 +115,38 @@ func FromPlaylist(ctx *context.Context, playlist *models.Playlist, src FileSyste
 	}
 
 	// totalBytes is the total allowed size of the playlist
-	totalBytes := playlist.GetTotalSize(config.Destination, existingSize)
+	totalBytes, err := allocator.Budget(playlist, existingSize)
+	if err != nil {
+		logger.LogWarning("Skipping playlist: ", err.Error())
+		return
+	}
 
 	// playlist.Size is the remaining size of the playlist after removing existing files
 	playlist.Size = totalBytes - existingSize
 
+	// the rotated items were just removed, so they are replaced by fresh ones rather than copied again
+	playlist.Items = sync.WithoutRotated(&playlist.Items, rotated)
+
+	if playlist.Pack != "" {
+		// leave the margin at which the copy stops, so the last chosen item isn't removed again
+		if err := selection.PackPlaylist(config, sizes, playlist, existingFiles, totalBytes-humanize.MiByte*50); err != nil {
+			logger.LogWarning("Skipping playlist: ", err.Error())
+			return
+		}
+	}
+
+	// clean already removed the copies over a quota, so this only counts the rest
+	usage, err := selection.NewUsage(playlist.Quotas)
+	if err != nil {
+		logger.LogWarning("Skipping playlist: ", err.Error())
+		return
+	}
+	usage.Trim(&playlist.Items, existingFiles)
+
 	logger.LogInfo("Starting conversion")
 
 	start := time.Now().Add(-time.Second)
 
-	var i = 0
 	// loop through each playlist item
 mainLoop:
 	for item := playlist.Items.Front(); item != nil; item = item.Next() {
@@ -105,15 +164,34 @@ mainLoop:
 			}
 		}
 
-		// get the size of the original file
-		originalBytes := int64(item.Value.GetSize(src))
+		if item.Value.Size == 0 {
+			// Plex didn't report the size, so estimate from the original file
+			item.Value.Size = int64(item.Value.GetSize(src))
+		}
+		estimate := sizes.Estimate(config, item.Value)
+		needed := estimate + humanize.MiByte*50
+
+		if fits, full := usage.Fits(item.Value, estimate); !fits {
+			logger.LogInfo("Skipping", item.Value.Paths[0], "- over the quota,", full)
+			continue mainLoop
+		}
+
+		// skip the item before spending time on it if its estimate doesn't fit, even after removing the lower
+		// priority items
+		if playlist.Size > humanize.MiByte*50 &&
+			needed > playlist.Size+removableBytes(item, playlist.Items.Back(), existingFiles) {
+			logger.LogInfo("Skipping", item.Value.Paths[0], "- estimated at", humanize.Bytes(uint64(estimate)),
+				"with", humanizeNegBytes(playlist.Size), "left")
+			sizes.Skip(estimate)
+			continue mainLoop
+		}
 
 		// if the remaining size available for the playlist is less than the size of the item, remove any extraneous
 		// items from the dest directory until the size needed to copy the item is available
-		if playlist.Size < originalBytes && i != playlist.Items.Len()-1 {
-			logger.LogInfo("Making space - Checking if any of the", playlist.Items.Len()-i, "lower priority items exist")
-			toRemove := originalBytes - playlist.Size
-			clearedBytes := removeLast(ctx, toRemove, dest, item, playlist.Items.Back(), &existingFiles)
+		if playlist.Size < needed {
+			logger.LogInfo("Making space - Checking if any of the lower priority items exist")
+			toRemove := needed - playlist.Size
+			clearedBytes := removeLast(ctx, toRemove, dest, item, playlist.Items.Back(), &existingFiles, usage)
 			if clearedBytes > 0 {
 				logger.LogInfo("Removed last", humanize.Bytes(uint64(clearedBytes)), " from ", playlist.Name)
 				playlist.Size += clearedBytes
@@ -133,6 +211,8 @@ mainLoop:
 				continue mainLoop
 			}
 			playlist.Size = playlist.Size - int64(size)
+			sizes.Record(config, item.Value, int64(size))
+			usage.Add(item.Value, int64(size))
 		}
 
 		// Are we done?
@@ -147,11 +227,17 @@ mainLoop:
 			break mainLoop
 		}
 
-		playlist.Size, totalBytes = checkFreeSpace(config.Destination, playlist.Size, playlist.GetBase(), totalBytes)
+		if destFile != nil {
+			history.Record(item.Keys, time.Now())
+			if scans != nil {
+				scans.Add(destFile.GetRelativePath())
+			}
+		}
+
+		playlist.Size, totalBytes = allocator.Check(playlist, playlist.Size, totalBytes)
 
 		progress <- playlist
 		logger.LogVerbose("Moving to next item")
-		i++
 	}
 	logger.LogInfo(logger.Green, "Finished copying ", playlist.Name, logger.Reset)
 }
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/dustin/go-humanize"
 	ffmpeg_go "github.com/u2takey/ffmpeg-go"
+	"os"
 	"plex-go-sync/internal/ffmpeg"
 	. "plex-go-sync/internal/filesystem"
 	"plex-go-sync/internal/logger"
@@ -20,8 +21,20 @@ const fuzzySize = 20 * humanize.MiByte
 // Transcode reencodes a video file to the correct format and copies it to the destination
 func Transcode(ctx *context.Context, id string, src FileSystem, dest FileSystem, item models.PlaylistItem) (File, uint64, error) {
 	var config = models.GetConfig(ctx)
+	if item.Type == "track" {
+		return transcodeAudio(ctx, id, src, dest, item)
+	}
 
 	for _, srcPath := range item.Paths {
+		if parts := item.GetParts(srcPath); len(parts) > 1 {
+			base, _ := getExtension(srcPath)
+			destFile := dest.GetFile(base + "." + config.MediaFormat.Format)
+			size, err := concatParts(ctx, src, parts, destFile, item.Duration, id+base)
+			if err == nil {
+				return destFile, size, err
+			}
+			continue
+		}
 		srcFile := src.GetFile(srcPath)
 
 		copyFile, duration, audioStreams, _ := ffmpeg.Probe(ctx, srcFile, 0)
@@ -50,11 +63,7 @@ func tryConvert(ctx *context.Context, srcFile File, destFile File, copyFile bool
 		size, err := destFile.CopyFrom(ctx, srcFile.GetFileSystem(), id)
 		return size, err
 	} else if copyFile { // Do a format conversion
-		kwargs = addAudioStream(ffmpeg_go.KwArgs{
-			"vcodec":   "copy",
-			"format":   config.MediaFormat.Format,
-			"loglevel": "error", "y": "",
-		}, audioStreams)
+		kwargs = copyArgs(config, audioStreams)
 		progress, msg := ffmpeg.Convert(ctx, srcFile, destFile, duration, kwargs)
 		totalSize, err = watchProgress(progress, msg, id)
 		if err != nil && err.Error() == "codec not currently supported in container" {
@@ -64,13 +73,7 @@ func tryConvert(ctx *context.Context, srcFile File, destFile File, copyFile bool
 	} else if config.FastConvert { // Skip this file
 		return 0, errors.New("file must be converted, skipping because we are in -fast mode")
 	} else { // Do a full re-encode
-		kwargs = addAudioStream(ffmpeg_go.KwArgs{
-			"c:v":      "libx264",
-			"crf":      strconv.Itoa(config.MediaFormat.CrfFilter),
-			"s":        fmt.Sprintf("%dx%d", config.MediaFormat.WidthFilter, config.MediaFormat.HeightFilter),
-			"format":   config.MediaFormat.Format,
-			"loglevel": "error", "y": "",
-		}, audioStreams)
+		kwargs = encodeArgs(config, audioStreams)
 		progress, msg := ffmpeg.Convert(ctx, srcFile, destFile, duration, kwargs)
 		totalSize, err = watchProgress(progress, msg, id)
 	}
@@ -83,6 +86,140 @@ func tryConvert(ctx *context.Context, srcFile File, destFile File, copyFile bool
 	return totalSize, err
 }
 
+// transcodeAudio copies a music track, converting it to the configured audio format unless it already
+// uses the same codec at no more than the configured bitrate. The artist/album folders of the source are kept.
+func transcodeAudio(ctx *context.Context, id string, src FileSystem, dest FileSystem, item models.PlaylistItem) (File, uint64, error) {
+	var config = models.GetConfig(ctx)
+	format := config.AudioFormat
+
+	for _, srcPath := range item.Paths {
+		srcFile := src.GetFile(srcPath)
+		codec, bitrate, duration, err := ffmpeg.ProbeAudio(ctx, srcFile)
+		if err != nil {
+			continue
+		}
+
+		base, _ := getExtension(srcPath)
+		destFile := dest.GetFile(base + "." + format.Extension())
+		var size uint64
+		if srcFile.GetExtension()[1:] == format.Extension() && strings.Contains(format.Codec(), codec) &&
+			bitrate > 0 && bitrate <= format.GetBitrate() {
+			size, err = destFile.CopyFrom(ctx, srcFile.GetFileSystem(), id)
+		} else if config.FastConvert {
+			err = errors.New("file must be converted, skipping because we are in -fast mode")
+		} else {
+			progress, msg := ffmpeg.Convert(ctx, srcFile, destFile, duration, ffmpeg_go.KwArgs{
+				"vn":       "",
+				"c:a":      format.Codec(),
+				"b:a":      format.Bitrate,
+				"format":   format.Muxer(),
+				"loglevel": "error", "y": "",
+			})
+			size, err = watchProgress(progress, msg, id+base)
+		}
+		if err == nil && size > 0 {
+			return destFile, size, nil
+		}
+	}
+
+	return nil, 0, errors.New("could not transcode track")
+}
+
+// concatParts joins the parts of a multi-part media into one file. Remote parts are copied to the temp dir
+// first, since ffmpeg can only concatenate files it can open itself.
+func concatParts(ctx *context.Context, src FileSystem, parts []string, destFile File, duration time.Duration, id string) (uint64, error) {
+	var config = models.GetConfig(ctx)
+	local := src
+	if !src.IsLocal() {
+		if config.TempDir != "" {
+			if err := os.MkdirAll(config.TempDir, 0755); err != nil {
+				return 0, err
+			}
+		}
+		tempDir, err := os.MkdirTemp(config.TempDir, "plex-go-sync-parts-")
+		if err != nil {
+			return 0, err
+		}
+		defer func() {
+			_ = os.RemoveAll(tempDir)
+		}()
+		local = NewLocalFileSystem(tempDir)
+	}
+
+	files := make([]File, len(parts))
+	for i, part := range parts {
+		files[i] = local.GetFile(part)
+		if local != src {
+			logger.LogVerbose("Copying part", i+1, "of", len(parts), part)
+			if _, err := files[i].CopyFrom(ctx, src, id); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	// the first part decides how the rest are handled, unless the streams of the parts differ and can't be
+	// joined as they are
+	copyFile, _, audioStreams, _ := ffmpeg.Probe(ctx, files[0], 0)
+	if copyFile && !sameLayout(ctx, files) {
+		logger.LogInfo("The parts of", destFile.GetRelativePath(), "have different streams, re-encoding them")
+		copyFile = false
+	}
+	var kwargs ffmpeg_go.KwArgs
+	if copyFile {
+		kwargs = copyArgs(config, audioStreams)
+	} else if config.FastConvert {
+		return 0, errors.New("file must be converted, skipping because we are in -fast mode")
+	} else {
+		kwargs = encodeArgs(config, audioStreams)
+	}
+
+	progress, msg := ffmpeg.Concat(ctx, files, destFile, duration, kwargs)
+	totalSize, err := watchProgress(progress, msg, id)
+	if err == nil && totalSize <= 0 {
+		err = errors.New("file has " + strconv.FormatUint(totalSize, 10) + " bytes")
+		logger.LogWarning(err)
+	}
+	if err != nil {
+		// a partial file would be taken for a finished copy on the next run
+		_ = destFile.Remove()
+	}
+	return totalSize, err
+}
+
+// sameLayout reports whether every part has the same streams, with the same codecs and formats
+func sameLayout(ctx *context.Context, files []File) bool {
+	first, err := ffmpeg.ProbeLayout(ctx, files[0])
+	if err != nil {
+		return false
+	}
+	for _, file := range files[1:] {
+		if layout, err := ffmpeg.ProbeLayout(ctx, file); err != nil || layout != first {
+			return false
+		}
+	}
+	return true
+}
+
+// copyArgs keeps the video stream as is, only changing the container and audio if needed
+func copyArgs(config *models.Config, audioStreams []int) ffmpeg_go.KwArgs {
+	return addAudioStream(ffmpeg_go.KwArgs{
+		"vcodec":   "copy",
+		"format":   config.MediaFormat.Format,
+		"loglevel": "error", "y": "",
+	}, audioStreams)
+}
+
+// encodeArgs reencodes the video to the configured size and quality
+func encodeArgs(config *models.Config, audioStreams []int) ffmpeg_go.KwArgs {
+	return addAudioStream(ffmpeg_go.KwArgs{
+		"c:v":      "libx264",
+		"crf":      strconv.Itoa(config.MediaFormat.CrfFilter),
+		"s":        fmt.Sprintf("%dx%d", config.MediaFormat.WidthFilter, config.MediaFormat.HeightFilter),
+		"format":   config.MediaFormat.Format,
+		"loglevel": "error", "y": "",
+	}, audioStreams)
+}
+
 func addAudioStream(kwargs ffmpeg_go.KwArgs, audioStreams []int) ffmpeg_go.KwArgs {
 	if len(audioStreams) == 0 {
 		kwargs["c:a"] = "aac"
@@ -96,6 +233,21 @@ func addAudioStream(kwargs ffmpeg_go.KwArgs, audioStreams []int) ffmpeg_go.KwArg
 	return kwargs
 }
 
+// progressLabel shortens the id to the start of the file path, after the playlist name and base directory
+func progressLabel(id string) string {
+	idx := 0
+	label := id[strings.IndexFunc(id, func(r rune) bool {
+		if r == '/' {
+			idx = idx + 1
+		}
+		return idx == 2
+	})+1:]
+	if len(label) > 24 {
+		label = label[:24]
+	}
+	return label
+}
+
 // watchProgress Display progress of the ffmpeg conversion
 func watchProgress(progress chan ffmpeg.FfmpegProps, errChan chan error, id string) (fileSize uint64, err error) {
 	totalSize := uint64(0)
@@ -105,13 +257,7 @@ func watchProgress(progress chan ffmpeg.FfmpegProps, errChan chan error, id stri
 			if more {
 				percent := float64(data.OutTime) / float64(data.Duration)
 				remaining := time.Duration((float64(data.Elapsed) / float64(data.OutTime+time.Second)) * float64(data.Duration-data.OutTime))
-				idx := 0
-				logger.Progress(id, percent, " at ", data.Speed+"x ", remaining.Round(time.Second).String(), " remaining \"", id[strings.IndexFunc(id, func(r rune) bool {
-					if r == '/' {
-						idx = idx + 1
-					}
-					return idx == 2
-				}):][1:25]+"...\"")
+				logger.Progress(id, percent, " at ", data.Speed+"x ", remaining.Round(time.Second).String(), " remaining \"", progressLabel(id)+"...\"")
 
 				if totalSize < data.TotalSize {
 					totalSize = data.TotalSize
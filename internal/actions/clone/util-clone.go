@@ -7,6 +7,7 @@ import (
 	"plex-go-sync/internal/logger"
 	"plex-go-sync/internal/models"
 	"plex-go-sync/internal/plex"
+	"plex-go-sync/internal/selection"
 	. "plex-go-sync/internal/structures"
 	"strings"
 	"time"
@@ -31,24 +32,18 @@ func getExtension(path string) (base string, ext string) {
 	return path, ""
 }
 
-// checkFreeSpace Check if we have enough free space to copy the next file
-func checkFreeSpace(root string, remainingBytes int64, base string, totalBytes int64) (remaining int64, total int64) {
-	fs := NewFileSystem(root)
-	free, err := fs.GetFreeSpace(base)
-	if err != nil {
-		return remainingBytes, totalBytes
-	}
-
-	usedBytes := totalBytes - remainingBytes
-
-	// if free space is less than the remaining allowed space, we need to decrease the allowed space
-	if int64(free) < remainingBytes {
-		logger.LogWarning("Not enough free space, adjusting remainingBytes from", humanize.Bytes(uint64(remainingBytes)), "to", humanize.Bytes(free))
-		remainingBytes = int64(free) - (500 * humanize.MiByte)
-		totalBytes = usedBytes + remainingBytes
+// newScanBatcher connects to the destination server so each cloned file can be scanned as soon as it lands.
+// Without it the new files only show up after the full refresh at the end of the clone.
+func newScanBatcher(ctx *context.Context, config *models.Config) *plex.ScanBatcher {
+	server, err := plex.Connect(config.DestinationConnection)
+	if err == nil {
+		var scans *plex.ScanBatcher
+		if scans, err = server.NewScanBatcher(ctx, plex.DefaultScanDelay); err == nil {
+			return scans
+		}
 	}
-	// leave some space on the drive
-	return remainingBytes, totalBytes
+	logger.LogWarning("Cloned items will not be scanned until the end: ", err.Error())
+	return nil
 }
 
 // displayCloneProcessStatus Display the current status of the clone process
@@ -69,8 +64,19 @@ func displayCloneProcessStatus(playlist *models.Playlist, existingSize int64, to
 	}
 }
 
+// removableBytes is the size of the existing copies removeLast could remove to make space for start
+func removableBytes(start *LinkedListItem[string, models.PlaylistItem], end *LinkedListItem[string, models.PlaylistItem], existing map[string]uint64) int64 {
+	removable := int64(0)
+	for item := end; item != start; item = item.Prev() {
+		for _, path := range item.Value.Paths {
+			removable += int64(existing[plex.GetKey(path)])
+		}
+	}
+	return removable
+}
+
 // removeLast Remove items from the end of the playlist until we have enough space to copy the next file
-func removeLast(ctx *context.Context, bytes int64, dest FileSystem, start *LinkedListItem[string, models.PlaylistItem], end *LinkedListItem[string, models.PlaylistItem], existing *map[string]uint64) int64 {
+func removeLast(ctx *context.Context, bytes int64, dest FileSystem, start *LinkedListItem[string, models.PlaylistItem], end *LinkedListItem[string, models.PlaylistItem], existing *map[string]uint64, usage *selection.Usage) int64 {
 	var config = models.GetConfig(ctx)
 	removed := int64(0)
 
@@ -80,11 +86,13 @@ func removeLast(ctx *context.Context, bytes int64, dest FileSystem, start *Linke
 			if (*existing)[key] != 0 {
 				logger.LogVerbose("Removing ", path)
 				base, _ := getExtension(path)
-				if err := dest.Remove(base + "." + config.MediaFormat.Format); err != nil {
-					logger.LogWarning("error removing ", base+"."+config.MediaFormat.Format, ": ", err.Error())
+				ext := config.GetExtension(item.Value)
+				if err := dest.Remove(base + "." + ext); err != nil {
+					logger.LogWarning("error removing ", base+"."+ext, ": ", err.Error())
 				} else {
 					bytes -= int64((*existing)[key])
 					removed += int64((*existing)[key])
+					usage.Remove(item.Value, int64((*existing)[key]))
 					delete(*existing, key)
 				}
 			}
@@ -0,0 +1,31 @@
+package discover
+
+import (
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"os"
+	"plex-go-sync/internal/logger"
+	"plex-go-sync/internal/plex"
+	"text/tabwriter"
+)
+
+// FromContext lists the Plex servers which answer a GDM search on the local network
+func FromContext(c *cli.Context) error {
+	logger.SetLogLevel(c.String("loglevel"))
+	servers, err := plex.Discover(c.Duration("timeout"))
+	if err != nil {
+		logger.LogError(err.Error())
+		return err
+	}
+	if len(servers) == 0 {
+		logger.LogWarning("No Plex servers found")
+		return nil
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "NAME\tMACHINE IDENTIFIER\tADDRESS\tVERSION")
+	for _, server := range servers {
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", server.Name, server.MachineIdentifier, server.URL(), server.Version)
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,67 @@
+package paths
+
+import (
+	"errors"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"os"
+	"path"
+	"plex-go-sync/internal/logger"
+	"plex-go-sync/internal/models"
+	"plex-go-sync/internal/plex"
+	"strings"
+	"text/tabwriter"
+)
+
+// TestFromContext shows where each path reported by the source server is read from, where the clone is
+// written to and the path the destination server will report for it
+func TestFromContext(c *cli.Context) error {
+	logger.SetLogLevel(c.String("loglevel"))
+	if c.NArg() == 0 {
+		return errors.New("no path given, e.g. paths test \"/mnt/storage/tv/Show/Season 01/Show - s01e01.mkv\"")
+	}
+	config, err := models.ReadConfig(c)
+	if err != nil {
+		logger.LogError(err.Error())
+		return err
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for i, sample := range c.Args().Slice() {
+		if i > 0 {
+			_, _ = fmt.Fprintln(writer)
+		}
+		file := config.SourceConnection.Paths.Map(sample)
+		ext := config.GetExtension(models.PlaylistItem{Type: c.String("type")})
+		destFile := strings.TrimSuffix(file, path.Ext(file)) + "." + ext
+		share, _, _ := strings.Cut(strings.TrimLeft(file, "/"), "/")
+
+		_, _ = fmt.Fprintf(writer, "Source server\t%s\n", sample)
+		_, _ = fmt.Fprintf(writer, "Rule\t%s\n", describeRule(config.SourceConnection.Paths.Find(sample)))
+		_, _ = fmt.Fprintf(writer, "Source file\t%s\n", location(config.Source, file))
+		_, _ = fmt.Fprintf(writer, "Share\t%s\n", share)
+		_, _ = fmt.Fprintf(writer, "Key\t%s\n", plex.GetKey(file))
+		_, _ = fmt.Fprintf(writer, "Destination file\t%s\n", location(config.Destination, destFile))
+		if destServer, ok := config.DestinationConnection.Paths.Unmap(destFile); ok {
+			_, _ = fmt.Fprintf(writer, "Destination server\t%s\n", destServer)
+		} else {
+			_, _ = fmt.Fprintf(writer, "Destination server\tthe library folder named %s\n", share)
+		}
+	}
+	return writer.Flush()
+}
+
+// location joins a filesystem root such as smb://user@nas or /mnt/usb with a path
+func location(root string, file string) string {
+	if root == "" {
+		return file
+	}
+	return strings.TrimRight(root, "/") + "/" + strings.TrimLeft(file, "/")
+}
+
+func describeRule(rule models.PathRule, found bool) string {
+	if !found {
+		return "none, the first folder is the share name"
+	}
+	return rule.From + " -> " + rule.To
+}
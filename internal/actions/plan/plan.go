@@ -0,0 +1,312 @@
+package plan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"github.com/urfave/cli/v2"
+	"io"
+	"os"
+	"path"
+	"plex-go-sync/internal/actions/clean"
+	"plex-go-sync/internal/actions/sync"
+	"plex-go-sync/internal/filesystem"
+	"plex-go-sync/internal/logger"
+	"plex-go-sync/internal/models"
+	"plex-go-sync/internal/plex"
+	"plex-go-sync/internal/selection"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// stopMargin is the remaining size at which the clone stops copying a playlist
+const stopMargin = 50 * humanize.MiByte
+
+// Row is one action the clone would take
+type Row struct {
+	Action      string `json:"action"`
+	Source      string `json:"source,omitempty"`
+	Destination string `json:"destination"`
+	Size        int64  `json:"size"` // expected size on the destination, or the size a delete frees
+}
+
+// Total adds up the rows of one action
+type Total struct {
+	Items int   `json:"items"`
+	Size  int64 `json:"size"`
+}
+
+// PlaylistPlan is what the clone would do for one playlist
+type PlaylistPlan struct {
+	Name    string           `json:"name"`
+	Budget  int64            `json:"budget"`
+	Rows    []Row            `json:"rows"`
+	Totals  map[string]Total `json:"totals"`
+	Skipped int              `json:"skipped"` // items which would not fit
+	Error   string           `json:"error,omitempty"`
+}
+
+// rotation is what clone would rotate out before copying, see sync.Rotate
+type rotation struct {
+	ratingKeys map[string]bool // source rating keys of the items rotated out
+	files      map[string]bool // item keys of the files removed
+}
+
+// FromContext prints what clone would copy, transcode and delete for each playlist, without changing anything
+func FromContext(c *cli.Context) error {
+	level := c.String("loglevel")
+	if level == "" && c.Bool("json") {
+		// keep stdout for the json
+		level = "ERROR"
+	}
+	logger.SetLogLevel(level)
+	config, err := models.ReadConfig(c)
+	if err != nil {
+		logger.LogError(err.Error())
+		return err
+	}
+	if err := plex.ResolveServers(config); err != nil {
+		logger.LogError(err.Error())
+		return err
+	}
+	ctx := context.WithValue(c.Context, "config", config)
+
+	dest := filesystem.NewFileSystem(config.Destination)
+	sizes := selection.LoadSizeHistory(config)
+	// nothing is copied, so the space of each playlist stays reserved while the next ones are planned
+	allocator := models.NewAllocator(dest, config.Playlists)
+	plans := make([]PlaylistPlan, 0, len(config.Playlists)+1)
+	var rotated rotation
+	if config.Rotate {
+		var rotationPlan PlaylistPlan
+		rotationPlan, rotated = planRotation(&ctx, dest)
+		plans = append(plans, rotationPlan)
+	}
+	for i := range config.Playlists {
+		if models.IsDone(&ctx) {
+			break
+		}
+		plans = append(plans, planPlaylist(&ctx, &config.Playlists[i], dest, sizes, allocator, rotated))
+	}
+	filesystem.CloseAllSmbConnections()
+
+	return Write(os.Stdout, plans, c.Bool("json"))
+}
+
+// Write prints the plans as a table for each playlist, or as json for scripts
+func Write(w io.Writer, plans []PlaylistPlan, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(plans)
+	}
+	for _, plan := range plans {
+		printPlan(w, plan)
+	}
+	return nil
+}
+
+type entry struct {
+	row      Row
+	item     models.PlaylistItem
+	existing bool
+	planned  bool
+}
+
+// planRotation lists the files clone would rotate out, assuming the play state of every item can be synced
+func planRotation(ctx *context.Context, dest filesystem.FileSystem) (PlaylistPlan, rotation) {
+	plan := PlaylistPlan{Name: "Rotation", Totals: make(map[string]Total)}
+	rotated := rotation{ratingKeys: make(map[string]bool), files: make(map[string]bool)}
+	rotations, err := sync.FindRotations(ctx)
+	if err != nil {
+		plan.Error = err.Error()
+		return plan, rotated
+	}
+	for _, r := range rotations {
+		rotated.ratingKeys[r.Source.RatingKey] = true
+		for _, file := range r.Files {
+			size, _ := dest.GetSize(file)
+			plan.add(Row{Action: selection.ActionDelete, Destination: file, Size: int64(size)})
+			rotated.files[plex.GetKey(file)] = true
+		}
+	}
+	return plan, rotated
+}
+
+// planPlaylist follows the steps of clone.FromPlaylist, with estimated sizes instead of copies
+func planPlaylist(ctx *context.Context, playlist *models.Playlist, dest filesystem.FileSystem,
+	sizes *selection.SizeHistory, allocator *models.Allocator, rotated rotation) PlaylistPlan {
+	config := models.GetConfig(ctx)
+	plan := PlaylistPlan{Name: playlist.Name, Totals: make(map[string]Total)}
+
+	var deletes []Row
+	record := func(_ filesystem.FileSystem, file string, size uint64, _ time.Duration) {
+		if !rotated.files[plex.GetKey(file)] {
+			deletes = append(deletes, Row{Action: selection.ActionDelete, Destination: file, Size: int64(size)})
+		}
+	}
+	existing, existingSize, err := clean.Inspect(ctx, playlist, dest, record)
+	if err != nil {
+		plan.Error = err.Error()
+		return plan
+	}
+	// the rotated files are already gone, and their items are replaced by fresh ones like in the clone
+	freed := int64(0)
+	for key := range rotated.files {
+		if size, ok := existing[key]; ok {
+			freed += int64(size)
+			existingSize -= int64(size)
+			delete(existing, key)
+		}
+	}
+	playlist.Items = sync.WithoutRotated(&playlist.Items, rotated.ratingKeys)
+
+	totalBytes, err := allocator.Budget(playlist, existingSize)
+	if err != nil {
+		plan.Error = err.Error()
+		return plan
+	}
+	if playlist.RawSize == "" {
+		// nothing was deleted, so the free space doesn't include the deletes yet
+		totalBytes += freed
+		for _, row := range deletes {
+			totalBytes += row.Size
+		}
+	}
+	return Copies(config, playlist, existing, existingSize, totalBytes, deletes, sizes)
+}
+
+// Copies follows the copy loop of clone.FromPlaylist once the existing files of a playlist are known, with
+// estimated sizes instead of copies. deletes are the files clean would remove before the copy starts.
+func Copies(config *models.Config, playlist *models.Playlist, existing map[string]uint64, existingSize int64,
+	totalBytes int64, deletes []Row, sizes *selection.SizeHistory) PlaylistPlan {
+	plan := PlaylistPlan{Name: playlist.Name, Budget: totalBytes, Totals: make(map[string]Total)}
+	remaining := totalBytes - existingSize
+
+	if playlist.Pack != "" {
+		if err := selection.PackPlaylist(config, sizes, playlist, existing, totalBytes-stopMargin); err != nil {
+			plan.Error = err.Error()
+			return plan
+		}
+	}
+
+	usage, err := selection.NewUsage(playlist.Quotas)
+	if err != nil {
+		plan.Error = err.Error()
+		return plan
+	}
+	usage.Trim(&playlist.Items, existing)
+
+	var entries []*entry
+	for item := playlist.Items.Front(); item != nil; item = item.Next() {
+		srcPath := item.Value.Paths[0]
+		e := &entry{row: Row{Source: srcPath, Destination: destPath(config, item.Value, srcPath)}, item: item.Value}
+		for _, key := range item.Keys {
+			if size, ok := existing[key]; ok {
+				e.existing, e.planned = true, true
+				e.row.Action, e.row.Size = selection.ActionKeep, int64(size)
+				break
+			}
+		}
+		if !e.existing {
+			e.row.Action = selection.PredictAction(config, item.Value)
+			e.row.Size = sizes.Estimate(config, item.Value)
+		}
+		entries = append(entries, e)
+	}
+
+	stopped := false
+	for i, e := range entries {
+		if e.existing {
+			continue
+		}
+		if stopped || remaining <= stopMargin {
+			stopped = true
+			plan.Skipped++
+			continue
+		}
+		// like the clone, skip items which won't fit even after removing the copies at the end of the playlist,
+		// otherwise remove those copies until the item fits
+		needed := e.row.Size + stopMargin
+		if fits, _ := usage.Fits(e.item, e.row.Size); !fits || needed > remaining+removable(entries[i+1:]) {
+			plan.Skipped++
+			continue
+		}
+		for j := len(entries) - 1; j > i && remaining < needed; j-- {
+			if entries[j].existing && entries[j].row.Action == selection.ActionKeep {
+				entries[j].row.Action = selection.ActionDelete
+				remaining += entries[j].row.Size
+				usage.Remove(entries[j].item, entries[j].row.Size)
+			}
+		}
+		remaining -= e.row.Size
+		usage.Add(e.item, e.row.Size)
+		e.planned = true
+	}
+
+	for _, e := range entries {
+		if e.planned {
+			plan.add(e.row)
+		}
+	}
+	for _, row := range deletes {
+		plan.add(row)
+	}
+	return plan
+}
+
+// removable is the size of the kept copies which could be deleted to make space
+func removable(entries []*entry) int64 {
+	size := int64(0)
+	for _, e := range entries {
+		if e.existing && e.row.Action == selection.ActionKeep {
+			size += e.row.Size
+		}
+	}
+	return size
+}
+
+func (p *PlaylistPlan) add(row Row) {
+	p.Rows = append(p.Rows, row)
+	total := p.Totals[row.Action]
+	total.Items++
+	total.Size += row.Size
+	p.Totals[row.Action] = total
+}
+
+// destPath returns the path an item is cloned to
+func destPath(config *models.Config, item models.PlaylistItem, srcPath string) string {
+	return strings.TrimSuffix(srcPath, path.Ext(srcPath)) + "." + config.GetExtension(item)
+}
+
+func printPlan(w io.Writer, plan PlaylistPlan) {
+	_, _ = fmt.Fprintln(w, logger.Green+plan.Name+logger.Reset)
+	if plan.Error != "" {
+		_, _ = fmt.Fprintln(w, "  "+plan.Error)
+		return
+	}
+
+	writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "ACTION\tSIZE\tSOURCE\tDESTINATION")
+	for _, row := range plan.Rows {
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", row.Action, humanize.Bytes(uint64(row.Size)), row.Source, row.Destination)
+	}
+	_ = writer.Flush()
+
+	var totals []string
+	for _, action := range []string{selection.ActionKeep, selection.ActionCopy, selection.ActionRemux,
+		selection.ActionTranscode, selection.ActionDelete} {
+		if total, ok := plan.Totals[action]; ok {
+			totals = append(totals, fmt.Sprintf("%s %d (%s)", action, total.Items, humanize.Bytes(uint64(total.Size))))
+		}
+	}
+	if plan.Skipped > 0 {
+		totals = append(totals, fmt.Sprintf("%d items don't fit", plan.Skipped))
+	}
+	if plan.Budget > 0 {
+		totals = append(totals, "budget "+humanize.Bytes(uint64(plan.Budget)))
+	}
+	_, _ = fmt.Fprintf(w, "Total: %s\n\n", strings.Join(totals, ", "))
+}
@@ -0,0 +1,117 @@
+package serve
+
+import (
+	"encoding/json"
+	"os"
+	"plex-go-sync/internal/logger"
+	"plex-go-sync/internal/models"
+	"sync"
+	"time"
+)
+
+// pendingEvent is a webhook which has not been synced yet. It is saved as soon as it is received and only removed
+// once it has synced, so neither a crash nor a restart loses it.
+type pendingEvent struct {
+	Server    string    `json:"server"` // machine identifier of the server which sent it
+	Account   string    `json:"account"`
+	RatingKey string    `json:"ratingKey"`
+	Event     string    `json:"event"`
+	Attempts  int       `json:"attempts"`
+	Added     time.Time `json:"added"`
+}
+
+func (e pendingEvent) id() string {
+	return e.Server + "/" + e.Account + "/" + e.RatingKey
+}
+
+// retryQueue holds pending events and persists them, so they survive a restart
+type retryQueue struct {
+	mutex  sync.Mutex
+	path   string
+	events []pendingEvent
+}
+
+func loadQueue(path string) *retryQueue {
+	q := &retryQueue{path: path}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return q
+	}
+	if err := json.Unmarshal(data, &q.events); err != nil {
+		logger.LogWarning("Error reading retry queue: ", err)
+	}
+	if len(q.events) > 0 {
+		logger.LogInfo(len(q.events), "webhook events waiting to be retried")
+	}
+	return q
+}
+
+// push adds an event, replacing an older event for the same item since only the latest play state matters
+func (q *retryQueue) push(event pendingEvent) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for i, queued := range q.events {
+		if queued.id() == event.id() {
+			q.events = append(q.events[:i], q.events[i+1:]...)
+			break
+		}
+	}
+	q.events = append(q.events, event)
+	q.save()
+}
+
+// pending returns a copy of the events which have not been tried yet, or of all events when retries is set
+func (q *retryQueue) pending(retries bool) []pendingEvent {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	var events []pendingEvent
+	for _, event := range q.events {
+		if retries || event.Attempts == 0 {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// update stores the attempts of an event, unless a newer webhook for the item has replaced it meanwhile
+func (q *retryQueue) update(event pendingEvent) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if i := q.indexOf(event); i >= 0 {
+		q.events[i] = event
+		q.save()
+	}
+}
+
+// remove drops an event which is done with, unless a newer webhook for the item has replaced it meanwhile
+func (q *retryQueue) remove(event pendingEvent) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if i := q.indexOf(event); i >= 0 {
+		q.events = append(q.events[:i], q.events[i+1:]...)
+		q.save()
+	}
+}
+
+func (q *retryQueue) indexOf(event pendingEvent) int {
+	for i, queued := range q.events {
+		if queued.id() == event.id() && queued.Added.Equal(event.Added) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (q *retryQueue) save() {
+	if len(q.events) == 0 {
+		_ = os.Remove(q.path)
+		return
+	}
+	data, err := json.MarshalIndent(q.events, "", "  ")
+	if err == nil {
+		err = models.WriteFileAtomic(q.path, append(data, '\n'), 0644)
+	}
+	if err != nil {
+		logger.LogWarning("Error writing retry queue: ", err)
+	}
+}
@@ -0,0 +1,257 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	client "github.com/jrudio/go-plex-client"
+	"github.com/urfave/cli/v2"
+	"net/http"
+	"plex-go-sync/internal/actions/sync"
+	"plex-go-sync/internal/logger"
+	"plex-go-sync/internal/models"
+	"plex-go-sync/internal/plex"
+	"strings"
+	"time"
+)
+
+const queueFile = "webhook-queue.json"
+
+var errUnknownServer = errors.New("webhook is not from the source or destination server")
+var errUnknownAccount = errors.New("account is not configured")
+
+// Receiver syncs the play state of items reported by Plex webhooks to the other server
+type Receiver struct {
+	config *models.Config
+	ids    map[string]string // machine identifier to source/destination
+	wake   chan struct{}
+	queue  *retryQueue
+	maxAge time.Duration // how long an event is retried, 0 to retry until it syncs
+}
+
+// NewReceiver creates a receiver which keeps the events it has not synced yet in the queue file at queuePath
+func NewReceiver(config *models.Config, queuePath string, maxAge time.Duration) *Receiver {
+	return &Receiver{
+		config: config,
+		ids:    make(map[string]string),
+		wake:   make(chan struct{}, 1),
+		queue:  loadQueue(queuePath),
+		maxAge: maxAge,
+	}
+}
+
+// FromContext listens for Plex webhooks and syncs each played item until interrupted
+func FromContext(c *cli.Context) error {
+	logger.SetLogLevel(c.String("loglevel"))
+	config, err := models.ReadConfig(c)
+	if err != nil {
+		logger.LogError(err.Error())
+		return err
+	}
+	if err := plex.ResolveServers(config); err != nil {
+		logger.LogError(err.Error())
+		return err
+	}
+
+	r := NewReceiver(config, config.DataFile(queueFile), c.Duration("max-age"))
+	ctx := c.Context
+	r.identifyServers(&ctx)
+
+	webhook := client.NewWebhook()
+	for _, register := range []func(func(client.Webhook)) error{webhook.OnScrobble, webhook.OnStop, webhook.OnPause} {
+		if err := register(r.Receive); err != nil {
+			return err
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/webhook", webhook.Handler)
+	httpServer := &http.Server{Addr: c.String("listen"), Handler: mux}
+
+	go r.work(&ctx, c.Duration("retry-interval"))
+	go func() {
+		<-ctx.Done()
+		logger.LogWarning("Received interrupt signal, stopping")
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = httpServer.Shutdown(shutdown)
+	}()
+
+	logger.LogInfo("Listening for webhooks on", httpServer.Addr+"/webhook")
+	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.LogError(err.Error())
+		return err
+	}
+	return nil
+}
+
+// identifyServers looks up the machine identifiers used to tell which server sent a webhook
+func (r *Receiver) identifyServers(ctx *context.Context) {
+	for origin, conn := range map[string]models.Connection{
+		"source":      r.config.SourceConnection,
+		"destination": r.config.DestinationConnection,
+	} {
+		if _, known := r.idOf(origin); known {
+			continue
+		}
+		server, err := plex.Connect(conn)
+		if err != nil {
+			logger.LogWarning("Could not connect to the", origin, "server:", err.Error())
+			continue
+		}
+		id, err := server.GetMachineIdentifier(ctx)
+		if err != nil {
+			logger.LogWarning("Could not identify the", origin, "server:", err.Error())
+			continue
+		}
+		r.ids[id] = origin
+	}
+}
+
+func (r *Receiver) idOf(origin string) (string, bool) {
+	for id, o := range r.ids {
+		if o == origin {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// Receive is called by the webhook handler. The event is saved before Plex is answered, and the worker is woken
+// to sync it.
+func (r *Receiver) Receive(w client.Webhook) {
+	if w.Metadata.RatingKey == "" {
+		return
+	}
+	account := ""
+	if !w.Owner {
+		account = w.Account.Title
+	}
+	logger.LogVerbose("Received", w.Event, "for", w.Metadata.Title, "from", w.Server.Title)
+	r.queue.push(pendingEvent{
+		Server:    w.Server.UUID,
+		Account:   account,
+		RatingKey: w.Metadata.RatingKey,
+		Event:     w.Event,
+		Added:     time.Now(),
+	})
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// originOf works out whether a webhook came from the source or the destination server
+func (r *Receiver) originOf(ctx *context.Context, server string) (string, error) {
+	origin, ok := r.ids[server]
+	if !ok {
+		r.identifyServers(ctx)
+		if origin, ok = r.ids[server]; !ok {
+			return "", errUnknownServer
+		}
+	}
+	return origin, nil
+}
+
+// work syncs new events as they are received, and retries the others on every tick
+func (r *Receiver) work(ctx *context.Context, retryInterval time.Duration) {
+	ticker := time.NewTicker(retryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.wake:
+			r.SyncQueued(ctx, false)
+		case <-ticker.C:
+			r.SyncQueued(ctx, true)
+		case <-(*ctx).Done():
+			return
+		}
+	}
+}
+
+// SyncQueued syncs the queued events which have not been tried yet, or all of them when retries is set. Events
+// stay queued until they have synced or never can.
+func (r *Receiver) SyncQueued(ctx *context.Context, retries bool) {
+	for _, event := range r.queue.pending(retries) {
+		if models.IsDone(ctx) {
+			return
+		}
+		r.process(ctx, event)
+	}
+}
+
+func (r *Receiver) process(ctx *context.Context, event pendingEvent) {
+	origin, err := r.originOf(ctx, event.Server)
+	if err == nil {
+		err = r.sync(ctx, origin, event)
+	}
+	event.Attempts++
+	switch {
+	case err == nil:
+	case errors.Is(err, errUnknownServer), errors.Is(err, errUnknownAccount):
+		logger.LogVerbose("Ignoring", event.Event, "for item", event.RatingKey+":", err.Error())
+	case plex.IsUnauthorized(err), plex.IsNotFound(err):
+		// retrying won't help with a token which may not see the item, or an item which is gone
+		logger.LogError("Giving up on item", event.RatingKey, "from the", origin, "server: ", err.Error())
+	case r.maxAge > 0 && time.Since(event.Added) > r.maxAge:
+		logger.LogError("Giving up on item", event.RatingKey, "from the", origin, "server after",
+			event.Attempts, "attempts: ", err.Error())
+	default:
+		logger.LogWarning("Could not sync item", event.RatingKey, "from the", origin, "server, will retry:", err.Error())
+		r.queue.update(event)
+		return
+	}
+	r.queue.remove(event)
+}
+
+func (r *Receiver) sync(ctx *context.Context, origin string, event pendingEvent) error {
+	from, to, err := r.connect(ctx, origin, event)
+	if err != nil {
+		return err
+	}
+	changed, err := sync.SyncSingleItem(ctx, from, event.RatingKey, to)
+	if err != nil {
+		return err
+	}
+	if changed {
+		logger.LogInfo(logger.Green+"Synced item", event.RatingKey, "from the", origin, "server"+logger.Reset)
+	}
+	return nil
+}
+
+// connect returns the server the event came from and the server to update, as the account which played it
+func (r *Receiver) connect(ctx *context.Context, origin string, event pendingEvent) (*plex.Server, *plex.Server, error) {
+	source, dest := r.config.SourceConnection, r.config.DestinationConnection
+	var from, to *plex.Server
+	var err error
+	if event.Account == "" {
+		from, err = plex.Connect(source)
+		if err == nil {
+			to, err = plex.Connect(dest)
+		}
+	} else {
+		user, ok := r.findUser(event.Account)
+		if !ok {
+			return nil, nil, fmt.Errorf("%s: %w", event.Account, errUnknownAccount)
+		}
+		from, err = sync.ConnectUser(ctx, source, user.SourceToken)
+		if err == nil {
+			to, err = sync.ConnectUser(ctx, dest, user.DestinationToken)
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if origin == "destination" {
+		from, to = to, from
+	}
+	return from, to, nil
+}
+
+func (r *Receiver) findUser(name string) (models.User, bool) {
+	for _, user := range r.config.Users {
+		if strings.EqualFold(user.Name, name) {
+			return user, true
+		}
+	}
+	return models.User{}, false
+}
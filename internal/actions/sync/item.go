@@ -0,0 +1,81 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	client "github.com/jrudio/go-plex-client"
+	"plex-go-sync/internal/plex"
+)
+
+var errNoMatch = errors.New("no matching item")
+var errAmbiguous = errors.New("more than one matching item")
+
+// SyncSingleItem copies the play state of one item from the server it was played on to the other server.
+// Errors mean one of the servers could not be read or written, so the caller may retry later.
+func SyncSingleItem(ctx *context.Context, from *plex.Server, ratingKey string, to *plex.Server) (bool, error) {
+	item, err := from.GetItem(ctx, ratingKey)
+	if err != nil {
+		return false, err
+	}
+	match, err := FindMatch(ctx, from, item, to)
+	if errors.Is(err, errNoMatch) || errors.Is(err, errAmbiguous) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if item.Type == "track" {
+		// ratings aren't part of the webhook, so only the play count is copied
+		direction, err := plex.SyncTrack(ctx, from, item, plex.Rating{}, to, match, plex.Rating{}, false, plex.SourceWins)
+		return direction != plex.NoChange, err
+	}
+	return to.SyncWatched(ctx, item, match)
+}
+
+// FindMatch finds the item on another server which corresponds to item, using the same rules as a full sync
+func FindMatch(ctx *context.Context, from *plex.Server, item client.Metadata, to *plex.Server) (client.Metadata, error) {
+	section, err := to.GetLibrarySection(ctx, item.LibrarySectionTitle)
+	if err != nil {
+		return client.Metadata{}, err
+	}
+	if item.Type == "track" {
+		tracks, err := to.IterateLibraryContent(ctx, section.Key, trackFilter).All()
+		if err != nil {
+			return client.Metadata{}, err
+		}
+		return matchOne(NewMatcher(tracks, to.Paths, from.Paths, trackKey), item)
+	}
+
+	library, err := to.IterateLibraryContent(ctx, section.Key, "?includeGuids=1").All()
+	if err != nil {
+		return client.Metadata{}, err
+	}
+	if item.Type != "episode" {
+		return matchOne(NewMatcher(library, to.Paths, from.Paths, TitleYearKey), item)
+	}
+
+	show, err := from.GetItem(ctx, item.GrandparentRatingKey)
+	if err != nil {
+		return client.Metadata{}, err
+	}
+	toShow, err := matchOne(NewMatcher(library, to.Paths, from.Paths, TitleYearKey), show)
+	if err != nil {
+		return client.Metadata{}, err
+	}
+	episodes, err := to.IterateAllLeaves(ctx, toShow.RatingKey).All()
+	if err != nil {
+		return client.Metadata{}, err
+	}
+	return matchOne(NewMatcher(episodes, to.Paths, from.Paths, EpisodeKey), item)
+}
+
+func matchOne(m *Matcher, item client.Metadata) (client.Metadata, error) {
+	match, result := m.Match(item)
+	switch result {
+	case MatchNone:
+		return match, errNoMatch
+	case MatchAmbiguous:
+		return match, errAmbiguous
+	}
+	return match, nil
+}
@@ -0,0 +1,124 @@
+package sync
+
+import (
+	"fmt"
+	client "github.com/jrudio/go-plex-client"
+	"plex-go-sync/internal/models"
+	"plex-go-sync/internal/plex"
+	"strings"
+)
+
+// match tiers, strongest first. An item is only paired using a weaker tier if
+// no stronger tier produced a candidate.
+const (
+	tierGuid = iota
+	tierExternalGuid
+	tierFileKey
+	tierFallback
+	tierCount
+)
+
+// MatchResult tells whether Match found the item, found nothing or found more than one candidate
+type MatchResult int
+
+const (
+	MatchFound MatchResult = iota
+	MatchNone
+	MatchAmbiguous
+)
+
+// FallbackFunc returns the weakest identifier of an item, e.g. title+year or season/episode number
+type FallbackFunc func(item client.Metadata) string
+
+// Matcher pairs destination items with source items by guid, external guid, file key and finally a fallback key.
+// File keys are taken from the filesystem paths, after applying the path rules of the server each item is from.
+type Matcher struct {
+	index      [tierCount]map[string][]int
+	items      []client.Metadata
+	queryPaths models.PathRules
+	fallback   FallbackFunc
+}
+
+// NewMatcher indexes items, which use the path rules paths, to match items which use queryPaths
+func NewMatcher(items []client.Metadata, paths models.PathRules, queryPaths models.PathRules, fallback FallbackFunc) *Matcher {
+	m := &Matcher{items: items, queryPaths: queryPaths, fallback: fallback}
+	for tier := range m.index {
+		m.index[tier] = make(map[string][]int)
+	}
+	for i, item := range items {
+		for tier, ids := range m.identifiers(item, paths) {
+			for _, id := range ids {
+				m.index[tier][id] = append(m.index[tier][id], i)
+			}
+		}
+	}
+	return m
+}
+
+// identifiers returns the lookup keys of an item for each tier
+func (m *Matcher) identifiers(item client.Metadata, paths models.PathRules) [tierCount][]string {
+	var ids [tierCount][]string
+	if isGlobalGuid(item.GUID) {
+		ids[tierGuid] = []string{item.GUID}
+	}
+	for _, guid := range item.AltGUIDs {
+		if guid.ID != "" {
+			ids[tierExternalGuid] = append(ids[tierExternalGuid], guid.ID)
+		}
+	}
+	for _, media := range item.Media {
+		for _, part := range media.Part {
+			if key := plex.GetKey(paths.Map(part.File)); key != "" {
+				ids[tierFileKey] = append(ids[tierFileKey], key)
+			}
+		}
+	}
+	if key := m.fallback(item); key != "" {
+		ids[tierFallback] = []string{key}
+	}
+	return ids
+}
+
+// Match finds the source item paired with the destination item. When a tier yields more than one distinct
+// candidate the match is reported as ambiguous rather than guessing.
+func (m *Matcher) Match(item client.Metadata) (client.Metadata, MatchResult) {
+	for tier, ids := range m.identifiers(item, m.queryPaths) {
+		candidates := make(map[int]bool)
+		for _, id := range ids {
+			for _, i := range m.index[tier][id] {
+				candidates[i] = true
+			}
+		}
+		if len(candidates) > 1 {
+			return client.Metadata{}, MatchAmbiguous
+		}
+		for i := range candidates {
+			return m.items[i], MatchFound
+		}
+	}
+	return client.Metadata{}, MatchNone
+}
+
+// isGlobalGuid local and agent-less guids are only meaningful on the server that created them
+func isGlobalGuid(guid string) bool {
+	return guid != "" &&
+		!strings.HasPrefix(guid, "local://") &&
+		!strings.HasPrefix(guid, "com.plexapp.agents.none://")
+}
+
+// TitleYearKey is the fallback key of movies and shows, the title and year
+func TitleYearKey(item client.Metadata) string {
+	if item.Title == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s (%d)", strings.ToLower(item.Title), item.Year)
+}
+
+// EpisodeKey is the fallback key of episodes within a show, the season and episode number. Episodes without
+// an episode number get no key, so they can't be paired with an unrelated episode.
+func EpisodeKey(item client.Metadata) string {
+	if item.Index <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("s%02de%02d", item.ParentIndex, item.Index)
+}
@@ -0,0 +1,65 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	client "github.com/jrudio/go-plex-client"
+	"plex-go-sync/internal/logger"
+	"plex-go-sync/internal/models"
+	"plex-go-sync/internal/plex"
+	"strings"
+)
+
+var trackFilter = fmt.Sprintf("?includeGuids=1&type=%d", plex.TrackType)
+
+// syncMusic copies the play counts and ratings of every track in a music section
+func syncMusic(ctx *context.Context, source *plex.Server, srcKey string, dest *plex.Server, destKey string, report *syncReport) {
+	srcTracks, err := source.IterateLibraryContent(ctx, srcKey, trackFilter).All()
+	if err != nil {
+		logger.LogWarning("Skipping library: ", err.Error())
+		return
+	}
+	srcRatings, err := source.GetRatings(ctx, srcKey, plex.TrackType)
+	if err != nil {
+		logger.LogWarning("Skipping library: ", err.Error())
+		return
+	}
+	destRatings, err := dest.GetRatings(ctx, destKey, plex.TrackType)
+	if err != nil {
+		logger.LogWarning("Skipping library: ", err.Error())
+		return
+	}
+
+	config := models.GetConfig(ctx)
+	policy, _ := plex.ParseConflictPolicy(config.ConflictPolicy)
+	trackMatcher := NewMatcher(srcTracks, source.Paths, dest.Paths, trackKey)
+	destTracks := dest.IterateLibraryContent(ctx, destKey, trackFilter)
+	for i := 0; destTracks.Next(); i++ {
+		destTrack := destTracks.Item()
+		logger.Progress("music", float64(i)/float64(destTracks.TotalSize()))
+
+		srcTrack, result := trackMatcher.Match(destTrack)
+		if result != MatchFound {
+			report.addMatch(result, destTrack)
+			continue
+		}
+		direction, err := plex.SyncTrack(ctx, source, srcTrack, srcRatings[srcTrack.RatingKey], dest, destTrack,
+			destRatings[destTrack.RatingKey], config.TwoWay, policy)
+		if direction != plex.NoChange || err != nil {
+			report.addChange(direction, destTrack, err)
+		}
+	}
+	logger.ProgressClear("music")
+	if err := destTracks.Err(); err != nil {
+		logger.LogWarning("Library sync incomplete: ", err.Error())
+	}
+}
+
+// trackKey is the fallback key for tracks, the artist, album, disc and track number
+func trackKey(item client.Metadata) string {
+	if item.GrandparentTitle == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s/%d-%d", strings.ToLower(item.GrandparentTitle), strings.ToLower(item.ParentTitle),
+		item.ParentIndex, item.Index)
+}
@@ -0,0 +1,110 @@
+package sync
+
+import (
+	"context"
+	client "github.com/jrudio/go-plex-client"
+	"plex-go-sync/internal/logger"
+	"plex-go-sync/internal/models"
+	"plex-go-sync/internal/plex"
+	"strings"
+)
+
+// RecreatePlaylists copies each configured playlist to the destination server, in the source order and
+// containing only the items which exist in the destination library
+func RecreatePlaylists(ctx *context.Context) error {
+	config := models.GetConfig(ctx)
+	source, err := plex.Connect(config.SourceConnection)
+	if err != nil {
+		return err
+	}
+	dest, err := plex.Connect(config.DestinationConnection)
+	if err != nil {
+		return err
+	}
+
+	destItems, err := getLibraryItems(ctx, dest)
+	if err != nil {
+		return err
+	}
+	destMatcher := NewMatcher(destItems, dest.Paths, source.Paths, playlistItemKey)
+
+	for _, playlist := range config.Playlists {
+		if models.IsDone(ctx) {
+			return nil
+		}
+		items, err := source.IteratePlaylistItems(ctx, playlist.Name)
+		if err != nil {
+			logger.LogWarning("Skipping playlist", playlist.Name, ":", err.Error())
+			continue
+		}
+
+		var ratingKeys []string
+		playlistType := "video"
+		seen := make(map[string]bool)
+		for items.Next() {
+			destItem, result := destMatcher.Match(items.Item())
+			if result != MatchFound || seen[destItem.RatingKey] {
+				continue
+			}
+			seen[destItem.RatingKey] = true
+			ratingKeys = append(ratingKeys, destItem.RatingKey)
+			if destItem.Type == "track" {
+				playlistType = "audio"
+			}
+		}
+		if err := items.Err(); err != nil {
+			logger.LogWarning("Skipping playlist", playlist.Name, ":", err.Error())
+			continue
+		}
+		if len(ratingKeys) == 0 {
+			logger.LogWarning("None of the items in", playlist.Name, "are on the destination server")
+			continue
+		}
+
+		if err := dest.ReplacePlaylist(ctx, playlist.Name, playlistType, ratingKeys); err != nil {
+			logger.LogWarning("Could not recreate playlist", playlist.Name, ":", err.Error())
+			continue
+		}
+		logger.LogInfo(logger.Green+"Recreated playlist", playlist.Name, "with", len(ratingKeys), "items"+logger.Reset)
+	}
+	return nil
+}
+
+// getLibraryItems returns every movie, episode and track on a server
+func getLibraryItems(ctx *context.Context, server *plex.Server) ([]client.Metadata, error) {
+	libraries, err := server.GetLibraries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var items []client.Metadata
+	for _, library := range libraries.MediaContainer.Directory {
+		filter := "?includeGuids=1"
+		if library.Type == "show" {
+			filter += "&type=4" // episodes
+		} else if library.Type == "artist" {
+			filter = trackFilter
+		} else if library.Type != "movie" {
+			continue
+		}
+		content, err := server.IterateLibraryContent(ctx, library.Key, filter).All()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, content...)
+	}
+	return items, nil
+}
+
+// playlistItemKey is the fallback key when matching playlist items across all libraries
+func playlistItemKey(item client.Metadata) string {
+	if item.Type == "episode" {
+		if key := EpisodeKey(item); key != "" {
+			return strings.ToLower(item.GrandparentTitle) + " " + key
+		}
+		return ""
+	}
+	if item.Type == "track" {
+		return trackKey(item)
+	}
+	return TitleYearKey(item)
+}
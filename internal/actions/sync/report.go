@@ -0,0 +1,81 @@
+package sync
+
+import (
+	"fmt"
+	client "github.com/jrudio/go-plex-client"
+	"plex-go-sync/internal/logger"
+	"plex-go-sync/internal/plex"
+	"sort"
+	gosync "sync"
+)
+
+// syncReport collects what happened to each item during a sync run, so it can be summarised at the end
+type syncReport struct {
+	mutex         gosync.Mutex
+	unmatched     []string
+	ambiguous     []string
+	toDestination []string
+	toSource      []string
+	failed        []string
+}
+
+func itemName(item client.Metadata) string {
+	if item.Type == "episode" && EpisodeKey(item) != "" {
+		return fmt.Sprintf("%s - %s - %s", item.GrandparentTitle, EpisodeKey(item), item.Title)
+	}
+	return item.Title
+}
+
+func (r *syncReport) addMatch(result MatchResult, item client.Metadata) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	switch result {
+	case MatchNone:
+		r.unmatched = append(r.unmatched, itemName(item))
+	case MatchAmbiguous:
+		r.ambiguous = append(r.ambiguous, itemName(item))
+	}
+}
+
+func (r *syncReport) addChange(direction plex.Direction, item client.Metadata, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err != nil {
+		r.failed = append(r.failed, itemName(item)+": "+err.Error())
+		return
+	}
+	switch direction {
+	case plex.ToDestination:
+		r.toDestination = append(r.toDestination, itemName(item))
+	case plex.ToSource:
+		r.toSource = append(r.toSource, itemName(item))
+	}
+}
+
+func (r *syncReport) Log() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, list := range [][]string{r.toDestination, r.toSource, r.ambiguous, r.unmatched, r.failed} {
+		sort.Strings(list)
+	}
+	for _, name := range r.toDestination {
+		logger.LogVerbose("Updated on destination:", name)
+	}
+	for _, name := range r.toSource {
+		logger.LogVerbose("Updated on source:", name)
+	}
+	for _, name := range r.ambiguous {
+		logger.LogWarning("Ambiguous match, skipped:", name)
+	}
+	for _, name := range r.unmatched {
+		logger.LogWarning("No match on source server:", name)
+	}
+	for _, name := range r.failed {
+		logger.LogWarning("Failed to update play state:", name)
+	}
+	logger.LogInfof(logger.Green+"%d updated on destination, %d updated on source"+logger.Reset+"\n",
+		len(r.toDestination), len(r.toSource))
+	if len(r.ambiguous)+len(r.unmatched) > 0 {
+		logger.LogInfo(len(r.ambiguous), "ambiguous and", len(r.unmatched), "unmatched items")
+	}
+}
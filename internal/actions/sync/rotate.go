@@ -0,0 +1,164 @@
+package sync
+
+import (
+	"context"
+	"github.com/dustin/go-humanize"
+	client "github.com/jrudio/go-plex-client"
+	"plex-go-sync/internal/filesystem"
+	"plex-go-sync/internal/logger"
+	"plex-go-sync/internal/models"
+	"plex-go-sync/internal/plex"
+	. "plex-go-sync/internal/structures"
+	"time"
+)
+
+// Rotation is an item which was watched on the destination server long enough ago to be rotated out
+type Rotation struct {
+	Item   client.Metadata // on the destination server
+	Source client.Metadata // the same item on the source server
+	Files  []string        // the files of the item, under the destination path
+}
+
+// Rotate removes the movies and episodes which were fully watched on the destination server at least
+// RotateAfter days ago, so clone can use the space for new items. The play state of each item is copied to the
+// source server first, and an item is kept if that fails. It returns the files removed, under the destination
+// path, and the source rating keys of the items rotated out.
+func Rotate(ctx *context.Context, dest filesystem.FileSystem) ([]string, map[string]bool, error) {
+	config := models.GetConfig(ctx)
+	rotations, err := FindRotations(ctx)
+	if err != nil || len(rotations) == 0 {
+		return nil, nil, err
+	}
+	source, err := plex.Connect(config.SourceConnection)
+	if err != nil {
+		return nil, nil, err
+	}
+	removed, rotated := RemoveRotations(ctx, dest, rotations, func(rotation Rotation) error {
+		_, err := source.SyncWatched(ctx, rotation.Item, rotation.Source)
+		return err
+	})
+	return removed, rotated, nil
+}
+
+// FindRotations finds the items Rotate would remove, with their files on the destination. Items which can't
+// be matched on the source server or found on the destination are kept.
+func FindRotations(ctx *context.Context) ([]Rotation, error) {
+	config := models.GetConfig(ctx)
+	source, err := plex.Connect(config.SourceConnection)
+	if err != nil {
+		return nil, err
+	}
+	destServer, err := plex.Connect(config.DestinationConnection)
+	if err != nil {
+		return nil, err
+	}
+
+	destItems, err := getLibraryItems(ctx, destServer)
+	if err != nil {
+		return nil, err
+	}
+	watched := WatchedBefore(destItems, time.Now().AddDate(0, 0, -config.RotateAfter))
+	if len(watched) == 0 {
+		logger.LogInfo("No watched items to rotate out")
+		return nil, nil
+	}
+
+	libraries, err := destServer.GetLibraries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	srcItems, err := getLibraryItems(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	srcMatcher := NewMatcher(srcItems, source.Paths, destServer.Paths, playlistItemKey)
+
+	var rotations []Rotation
+	for _, item := range watched {
+		srcItem, result := srcMatcher.Match(item)
+		if result != MatchFound {
+			logger.LogWarning("Keeping", itemName(item), "- it can't be matched on the source server")
+			continue
+		}
+		rotation := Rotation{Item: item, Source: srcItem}
+		for _, media := range item.Media {
+			for _, part := range media.Part {
+				file, found := plex.FindLocalFile(libraries.MediaContainer.Directory, destServer.Paths, part.File)
+				if !found {
+					logger.LogWarning("Keeping", part.File, "- no library folder or path rule contains it")
+					continue
+				}
+				rotation.Files = append(rotation.Files, file)
+			}
+		}
+		if len(rotation.Files) > 0 {
+			rotations = append(rotations, rotation)
+		}
+	}
+	return rotations, nil
+}
+
+// RemoveRotations copies the play state of each item to the source server with syncWatched, then removes its
+// files. Items whose play state couldn't be copied are kept. It returns the files removed and the source
+// rating keys of the items rotated out.
+func RemoveRotations(ctx *context.Context, dest filesystem.FileSystem, rotations []Rotation,
+	syncWatched func(Rotation) error) ([]string, map[string]bool) {
+	var removed []string
+	rotated := make(map[string]bool)
+	freed := uint64(0)
+	for _, rotation := range rotations {
+		if models.IsDone(ctx) {
+			break
+		}
+		if err := syncWatched(rotation); err != nil {
+			logger.LogWarning("Keeping", itemName(rotation.Item), "- the play state couldn't be synced:", err.Error())
+			continue
+		}
+
+		for _, file := range rotation.Files {
+			size, _ := dest.GetSize(file)
+			if err := dest.Remove(file); err != nil {
+				logger.LogWarning("Error removing", file, ":", err.Error())
+				continue
+			}
+			removed = append(removed, file)
+			freed += size
+			rotated[rotation.Source.RatingKey] = true
+		}
+		if rotated[rotation.Source.RatingKey] {
+			logger.LogInfo("Rotated out", itemName(rotation.Item))
+		}
+	}
+	logger.LogInfo(logger.Green+"Rotated out", len(rotated), "items, freeing", humanize.Bytes(freed)+logger.Reset)
+	return removed, rotated
+}
+
+// WithoutRotated returns the items of a playlist which were not rotated out, by source rating key
+func WithoutRotated(items *OrderedMap[models.PlaylistItem], rotated map[string]bool) OrderedMap[models.PlaylistItem] {
+	if len(rotated) == 0 {
+		return *items
+	}
+	kept := NewOrderedMap[models.PlaylistItem]()
+	for item := items.Front(); item != nil; item = item.Next() {
+		if !rotated[item.Value.RatingKey] {
+			kept.SetAll(item.Keys, item.Value)
+		}
+	}
+	return kept
+}
+
+// WatchedBefore returns the movies and episodes which were fully watched and last viewed before cutoff
+func WatchedBefore(items []client.Metadata, cutoff time.Time) []client.Metadata {
+	var watched []client.Metadata
+	for _, item := range items {
+		state := plex.GetWatchState(item)
+		if item.Type == "track" || !state.Watched || state.ViewOffset > 0 {
+			continue
+		}
+		if int64(state.LastViewedAt) > cutoff.Unix() {
+			continue
+		}
+		watched = append(watched, item)
+	}
+	return watched
+}
@@ -2,10 +2,12 @@ package sync
 
 import (
 	"context"
+	client "github.com/jrudio/go-plex-client"
 	"github.com/urfave/cli/v2"
 	"plex-go-sync/internal/logger"
 	"plex-go-sync/internal/models"
 	"plex-go-sync/internal/plex"
+	gosync "sync"
 )
 
 func FromContext(c *cli.Context) error {
@@ -16,9 +18,13 @@ func FromContext(c *cli.Context) error {
 		logger.LogError(err.Error())
 		return err
 	}
+	if err := plex.ResolveServers(config); err != nil {
+		logger.LogError(err.Error())
+		return err
+	}
 
 	ctx := context.WithValue(c.Context, "config", config)
-	err = SyncLibraries(&ctx)
+	err = SyncLibraries(&ctx, true)
 	if err != nil {
 		logger.LogError(err.Error())
 	}
@@ -26,84 +32,138 @@ func FromContext(c *cli.Context) error {
 	return nil
 }
 
-func SyncLibrary(key string, source *plex.Server, dest *plex.Server) {
-	destLibrary, err := dest.GetLibraryContent(key, "")
+func SyncLibrary(ctx *context.Context, key string, source *plex.Server, dest *plex.Server, report *syncReport) {
+	destSection, err := dest.GetLibrarySection(ctx, key)
+	if err != nil {
+		logger.LogWarning("Skipping library: ", err.Error())
+		return
+	}
+	srcSection, err := source.GetLibrarySection(ctx, destSection.Title)
 	if err != nil {
 		logger.LogWarning("Skipping library: ", err.Error())
 		return
 	}
-	srcLibrary, libType, err := source.GetLibrarySectionByName(destLibrary.MediaContainer.LibrarySectionTitle, "")
+	if srcSection.Type == "artist" {
+		syncMusic(ctx, source, srcSection.Key, dest, key, report)
+		return
+	}
+	srcLibrary, err := source.IterateLibraryContent(ctx, srcSection.Key, "?includeGuids=1").All()
 	if err != nil {
 		logger.LogWarning("Skipping library: ", err.Error())
 		return
 	}
 
-	if libType == "show" {
-		for i, show := range destLibrary.MediaContainer.Metadata {
-			progress := float64(i) / float64(len(destLibrary.MediaContainer.Metadata))
+	libraryMatcher := NewMatcher(srcLibrary, source.Paths, dest.Paths, TitleYearKey)
+	destLibrary := dest.IterateLibraryContent(ctx, key, "?includeGuids=1")
+
+	if srcSection.Type == "show" {
+		for i := 0; destLibrary.Next(); i++ {
+			show := destLibrary.Item()
+			progress := float64(i) / float64(destLibrary.TotalSize())
 			logger.Progress("tv", progress)
 
-			destEpisodes, err := dest.GetEpisodes(show.RatingKey)
-			if err != nil {
-				logger.LogWarning("Skipping show: ", err.Error())
-				continue
-			}
-			srcShow := ""
-			for _, srcItem := range srcLibrary.MediaContainer.Metadata {
-				if srcItem.Title == show.Title {
-					srcShow = srcItem.RatingKey
-					break
-				}
-			}
-			if srcShow == "" {
+			srcShow, result := libraryMatcher.Match(show)
+			if result != MatchFound {
+				report.addMatch(result, show)
 				continue
 			}
-			srcEpisodes, err := source.GetEpisodes(srcShow)
+			srcEpisodes, err := source.IterateAllLeaves(ctx, srcShow.RatingKey).All()
 			if err != nil {
 				logger.LogWarning("Skipping show: ", err.Error())
 				continue
 			}
-			// TODO: optimize this
-			for _, destEpisode := range destEpisodes.MediaContainer.Metadata {
-				for _, srcEpisode := range srcEpisodes.MediaContainer.Metadata {
-					if srcEpisode.ParentIndex == destEpisode.ParentIndex && srcEpisode.Index == destEpisode.Index {
-						dest.SyncWatched(srcEpisode, destEpisode)
-					}
+			episodeMatcher := NewMatcher(srcEpisodes, source.Paths, dest.Paths, EpisodeKey)
+			destEpisodes := dest.IterateAllLeaves(ctx, show.RatingKey)
+			for destEpisodes.Next() {
+				destEpisode := destEpisodes.Item()
+				srcEpisode, result := episodeMatcher.Match(destEpisode)
+				if result != MatchFound {
+					report.addMatch(result, destEpisode)
+					continue
 				}
+				syncItem(ctx, source, srcEpisode, dest, destEpisode, report)
+			}
+			if err := destEpisodes.Err(); err != nil {
+				logger.LogWarning("Skipping show: ", err.Error())
 			}
 		}
 		logger.ProgressClear("tv")
-	} else if libType == "movie" {
-		for i, destMovie := range destLibrary.MediaContainer.Metadata {
-			progress := float64(i) / float64(len(destLibrary.MediaContainer.Metadata))
+	} else if srcSection.Type == "movie" {
+		for i := 0; destLibrary.Next(); i++ {
+			destMovie := destLibrary.Item()
+			progress := float64(i) / float64(destLibrary.TotalSize())
 			logger.Progress("movie", progress)
 
-			for _, srcMovie := range srcLibrary.MediaContainer.Metadata {
-				if srcMovie.Title == destMovie.Title {
-					dest.SyncWatched(srcMovie, destMovie)
-				}
+			srcMovie, result := libraryMatcher.Match(destMovie)
+			if result != MatchFound {
+				report.addMatch(result, destMovie)
+				continue
 			}
+			syncItem(ctx, source, srcMovie, dest, destMovie, report)
 		}
 		logger.ProgressClear("movie")
 	}
+	if err := destLibrary.Err(); err != nil {
+		logger.LogWarning("Library sync incomplete: ", err.Error())
+	}
+}
 
+// syncItem copies the play state of a matched pair, one way from source to destination or in both directions
+func syncItem(ctx *context.Context, source *plex.Server, srcItem client.Metadata, dest *plex.Server, destItem client.Metadata, report *syncReport) {
+	config := models.GetConfig(ctx)
+	if !config.TwoWay {
+		changed, err := dest.SyncWatched(ctx, srcItem, destItem)
+		if changed || err != nil {
+			report.addChange(plex.ToDestination, destItem, err)
+		}
+		return
+	}
+	policy, _ := plex.ParseConflictPolicy(config.ConflictPolicy)
+	direction, err := plex.SyncWatchedBoth(ctx, source, srcItem, dest, destItem, policy)
+	report.addChange(direction, destItem, err)
 }
 
-func SyncLibraries(ctx *context.Context) error {
+// SyncLibraries syncs the play state of every library once it has been scanned. With refresh, every library is
+// scanned first, otherwise only the scans already running are waited for.
+func SyncLibraries(ctx *context.Context, refresh bool) error {
 	config := models.GetConfig(ctx)
-	source, err := plex.New(config.Server, config.Token)
+	if _, err := plex.ParseConflictPolicy(config.ConflictPolicy); err != nil {
+		return err
+	}
+	source, err := plex.Connect(config.SourceConnection)
 	if err != nil {
 		return err
 	}
 
-	dest, err := plex.New(config.DestinationServer, config.Token)
+	dest, err := plex.Connect(config.DestinationConnection)
 	if err != nil {
 		return err
 	}
 
-	lib := dest.RefreshLibraries(ctx)
+	var wg gosync.WaitGroup
+	accounts := getAccounts(ctx, config, source, dest)
+	var lib chan string
+	if refresh {
+		lib = dest.RefreshLibraries(ctx)
+	} else {
+		lib = dest.WaitForScans(ctx)
+	}
 	for key := range lib {
-		go SyncLibrary(key, source, dest)
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			// each user has their own play state, so every library is synced once per account
+			for _, acc := range accounts {
+				SyncLibrary(ctx, key, acc.source, acc.dest, acc.report)
+			}
+		}(key)
+	}
+	wg.Wait()
+	for _, acc := range accounts {
+		if acc.name != "" {
+			logger.LogInfo("Play state for user", acc.name)
+		}
+		acc.report.Log()
 	}
 	return nil
 }
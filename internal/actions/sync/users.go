@@ -0,0 +1,57 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"plex-go-sync/internal/logger"
+	"plex-go-sync/internal/models"
+	"plex-go-sync/internal/plex"
+)
+
+// ErrNoToken is returned for a user without a token for the server
+var ErrNoToken = errors.New("no token configured")
+
+// account is a user whose play state is synced between the two servers
+type account struct {
+	name   string
+	source *plex.Server
+	dest   *plex.Server
+	report *syncReport
+}
+
+// getAccounts returns the admin account followed by every configured user which can reach both servers.
+// Users missing on either server are reported and skipped.
+func getAccounts(ctx *context.Context, config *models.Config, source *plex.Server, dest *plex.Server) []account {
+	accounts := []account{{name: "", source: source, dest: dest, report: &syncReport{}}}
+
+	for _, user := range config.Users {
+		userSource, err := ConnectUser(ctx, config.SourceConnection, user.SourceToken)
+		if err != nil {
+			logger.LogWarning("User", user.Name, "is not available on the source server, skipping:", err.Error())
+			continue
+		}
+		userDest, err := ConnectUser(ctx, config.DestinationConnection, user.DestinationToken)
+		if err != nil {
+			logger.LogWarning("User", user.Name, "is not available on the destination server, skipping:", err.Error())
+			continue
+		}
+		accounts = append(accounts, account{name: user.Name, source: userSource, dest: userDest, report: &syncReport{}})
+	}
+	return accounts
+}
+
+// ConnectUser connects to a server with a user token and checks the user can read its libraries
+func ConnectUser(ctx *context.Context, conn models.Connection, token string) (*plex.Server, error) {
+	if token == "" {
+		return nil, ErrNoToken
+	}
+	conn.Token = token
+	server, err := plex.Connect(conn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := server.GetLibraries(ctx); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
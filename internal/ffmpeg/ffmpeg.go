@@ -48,21 +48,60 @@ func (e *InputBufferError) Error() string {
 }
 
 func Convert(ctx *context.Context, in filesystem.File, out filesystem.File, duration time.Duration, kwargs ffmpeg_go.KwArgs) (chan FfmpegProps, chan error) {
+	logger.LogVerbose("Try ffmpeg convert: ", out.GetAbsolutePath())
+	if !in.IsLocal() {
+		return run(ctx, ffmpeg_go.Input("pipe:0"), in, out, duration, kwargs, nil)
+	}
+	return run(ctx, ffmpeg_go.Input(path.Clean(in.GetAbsolutePath())), nil, out, duration, kwargs, nil)
+}
+
+// Concat joins the parts of a multi-part media into one output with the concat demuxer. The parts must be
+// local files.
+func Concat(ctx *context.Context, parts []filesystem.File, out filesystem.File, duration time.Duration, kwargs ffmpeg_go.KwArgs) (chan FfmpegProps, chan error) {
+	logger.LogVerbose("Try ffmpeg concat: ", out.GetAbsolutePath())
+	list, err := os.CreateTemp("", "concat-*.txt")
+	if err == nil {
+		for _, part := range parts {
+			if !part.IsLocal() {
+				err = fmt.Errorf("part is not a local file: %s", part.GetAbsolutePath())
+				break
+			}
+			// the list is parsed like a shell string, so quotes in the path are escaped
+			escaped := strings.ReplaceAll(path.Clean(part.GetAbsolutePath()), "'", `'\''`)
+			if _, err = fmt.Fprintf(list, "file '%s'\n", escaped); err != nil {
+				break
+			}
+		}
+		_ = list.Close()
+	}
+	if err != nil {
+		msg := make(chan error, 1)
+		msg <- err
+		close(msg)
+		if list != nil {
+			_ = os.Remove(list.Name())
+		}
+		return make(chan FfmpegProps), msg
+	}
+
+	input := ffmpeg_go.Input(list.Name(), ffmpeg_go.KwArgs{"f": "concat", "safe": "0"})
+	return run(ctx, input, nil, out, duration, kwargs, func() {
+		_ = os.Remove(list.Name())
+	})
+}
+
+// run executes ffmpeg with the given input. in is only set when the input is piped from a remote file, and
+// cleanup is called once ffmpeg has exited.
+func run(ctx *context.Context, cmd *ffmpeg_go.Stream, in filesystem.File, out filesystem.File, duration time.Duration, kwargs ffmpeg_go.KwArgs, cleanup func()) (chan FfmpegProps, chan error) {
 	msg := make(chan error)
 	uri, socket := progressSocket(duration)
-	logger.LogVerbose("Try ffmpeg convert: ", out.GetAbsolutePath())
 
 	go func() {
 		defer close(msg)
-		buf := bytes.NewBuffer(nil)
-
-		var cmd *ffmpeg_go.Stream
-
-		if !in.IsLocal() {
-			cmd = ffmpeg_go.Input("pipe:0")
-		} else {
-			cmd = ffmpeg_go.Input(path.Clean(in.GetAbsolutePath()))
+		if cleanup != nil {
+			defer cleanup()
 		}
+		buf := bytes.NewBuffer(nil)
 
 		cmd.Context = *ctx
 
@@ -82,7 +121,7 @@ func Convert(ctx *context.Context, in filesystem.File, out filesystem.File, dura
 		cmd = cmd.GlobalArgs("-progress", uri).
 			WithErrorOutput(buf)
 
-		if !in.IsLocal() {
+		if in != nil {
 			reader, err := in.ReadFile()
 			if err != nil {
 				logger.LogWarning(err)
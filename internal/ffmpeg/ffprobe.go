@@ -4,12 +4,15 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"os/exec"
 	"plex-go-sync/internal/filesystem"
 	"plex-go-sync/internal/logger"
 	"plex-go-sync/internal/models"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -25,6 +28,9 @@ type probeStreams struct {
 	CodecType  string `json:"codec_type"`
 	Width      int    `json:"width"`
 	Height     int    `json:"height"`
+	PixFmt     string `json:"pix_fmt"`
+	SampleRate string `json:"sample_rate"`
+	Channels   int    `json:"channels"`
 	BitRate    string `json:"bit_rate"`
 	Duration   string `json:"duration"`
 	DurationTs int    `json:"duration_ts"`
@@ -49,7 +55,7 @@ func Probe(ctx *context.Context, file filesystem.File, size uint64) (ok bool, du
 	var config = models.GetConfig(ctx)
 	str, s2, err := callProbe(ctx, file, "-show_format", "-show_streams")
 	if err != nil {
-		logger.LogWarning("Error while probing file: %s", err.Error())
+		logger.LogWarning("Error while probing file:", err.Error())
 		return false, 0, []int{}, err
 	}
 	if size == 0 {
@@ -70,10 +76,35 @@ func Probe(ctx *context.Context, file filesystem.File, size uint64) (ok bool, du
 	return false, duration, audioStreams, err
 }
 
+// ProbeLayout describes the codec and format of each stream of a file. Files with the same layout can be joined
+// without re-encoding.
+func ProbeLayout(ctx *context.Context, file filesystem.File) (string, error) {
+	str, _, err := callProbe(ctx, file, "-show_streams")
+	if err != nil {
+		return "", err
+	}
+	pd := probeData{}
+	if err := json.Unmarshal([]byte(str), &pd); err != nil {
+		return "", err
+	}
+	var layout []string
+	for _, stream := range pd.Streams {
+		switch stream.CodecType {
+		case "video":
+			layout = append(layout, fmt.Sprintf("video %s %dx%d %s", stream.CodecName, stream.Width, stream.Height,
+				stream.PixFmt))
+		case "audio":
+			layout = append(layout, fmt.Sprintf("audio %s %sHz %dch", stream.CodecName, stream.SampleRate,
+				stream.Channels))
+		}
+	}
+	return strings.Join(layout, ", "), nil
+}
+
 func ProbeActualDuration(ctx *context.Context, file filesystem.File) (duration time.Duration, err error) {
 	str, _, err := callProbe(ctx, file, "-show_entries", "packet=duration_time,dts_time", "-read_intervals", "999999", "-select_streams", "a")
 	if err != nil {
-		logger.LogWarning("Error while probing file: %s", err.Error())
+		logger.LogWarning("Error while probing file:", err.Error())
 		return 0, err
 	}
 	pd := probeData{}
@@ -143,6 +174,32 @@ func getProbeData(result string, statSize uint64) (duration time.Duration, size
 	return time.Duration(durationSec * float64(time.Second)), size, bitrate, height, audioStreams, nil
 }
 
+// ProbeAudio returns the codec, bitrate and duration of the first audio stream of a music track
+func ProbeAudio(ctx *context.Context, file filesystem.File) (codec string, bitrate int, duration time.Duration, err error) {
+	str, _, err := callProbe(ctx, file, "-show_format", "-show_streams", "-select_streams", "a:0")
+	if err != nil {
+		logger.LogWarning("Error while probing file:", err.Error())
+		return "", 0, 0, err
+	}
+	return getAudioProbeData(str)
+}
+
+func getAudioProbeData(result string) (codec string, bitrate int, duration time.Duration, err error) {
+	pd := probeData{}
+	if err = json.Unmarshal([]byte(result), &pd); err != nil {
+		return "", 0, 0, err
+	}
+	if len(pd.Streams) == 0 {
+		return "", 0, 0, errors.New("no audio stream")
+	}
+	durationSec, _ := strconv.ParseFloat(pd.Format.Duration, 64)
+	bitrate, _ = strconv.Atoi(pd.Streams[0].BitRate)
+	if bitrate == 0 {
+		bitrate, _ = strconv.Atoi(pd.Format.BitRate)
+	}
+	return pd.Streams[0].CodecName, bitrate, time.Duration(durationSec * float64(time.Second)), nil
+}
+
 func callProbe(ctx *context.Context, file filesystem.File, args ...string) (string, int64, error) {
 	var cmd *exec.Cmd
 	var reader io.ReadCloser
@@ -28,6 +28,27 @@ func (f *LocalFileSystem) GetFreeSpace(base string) (uint64, error) {
 	return stat.Free, nil
 }
 
+func (f *LocalFileSystem) GetCapacity(base string) (uint64, error) {
+	stat, err := disk.Usage(path.Join(f.Path, base))
+	if err != nil {
+		return 0, err
+	}
+	return stat.Total, nil
+}
+
+// GetVolume identifies the volume of a base, or falls back to the destination path when the platform can't tell
+func (f *LocalFileSystem) GetVolume(base string) (string, error) {
+	dir := path.Join(f.Path, base)
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", err
+	}
+	if volume, ok := volumeID(dir, info); ok {
+		return volume, nil
+	}
+	return f.Path, nil
+}
+
 func (f *LocalFileSystem) GetFileSystem(base string) (fs.FS, error) {
 	dir := f.abs(base)
 	return os.DirFS(dir), nil
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package filesystem
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// volumeID identifies the volume of a directory by its device id
+func volumeID(_ string, info os.FileInfo) (string, bool) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return fmt.Sprint(stat.Dev), true
+	}
+	return "", false
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// volumeID identifies the volume of a directory by its drive letter or UNC share, since Windows has no device id
+func volumeID(dir string, _ os.FileInfo) (string, bool) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+	volume := filepath.VolumeName(abs)
+	return volume, volume != ""
+}
@@ -61,6 +61,27 @@ func (f *SmbFileSystem) GetFreeSpace(base string) (uint64, error) {
 	return stat.FreeBlockCount() * stat.BlockSize(), nil
 }
 
+func (f *SmbFileSystem) GetCapacity(base string) (uint64, error) {
+	share, _, err := f.smbMount(base)
+	if err != nil {
+		return 0, err
+	}
+	stat, err := share.Statfs(".")
+	if err != nil {
+		return 0, err
+	}
+	return stat.TotalBlockCount() * stat.BlockSize(), nil
+}
+
+// GetVolume identifies the volume of a base by its share
+func (f *SmbFileSystem) GetVolume(base string) (string, error) {
+	share, _, _ := strings.Cut(strings.TrimPrefix(base, "/"), "/")
+	if share == "" {
+		return "", errors.New("invalid path")
+	}
+	return "//" + f.Host + "/" + share, nil
+}
+
 func (f *SmbFileSystem) GetFileSystem(base string) (fs.FS, error) {
 	share, _, err := f.smbMount(base)
 	if err != nil {
@@ -20,6 +20,8 @@ type FileSystem interface {
 	RemoveAll(dir string) error
 	Mkdir(dir string) error
 	GetFreeSpace(base string) (uint64, error)
+	GetCapacity(base string) (uint64, error)
+	GetVolume(base string) (string, error)
 	GetFileSystem(base string) (fs.FS, error)
 	IsEmptyDir(dir string) bool
 }
@@ -2,6 +2,7 @@ package filesystem
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/dustin/go-humanize"
 	"io"
@@ -69,3 +70,45 @@ func copyFile(ctx *context.Context, from File, to io.WriteCloser, toPath string,
 	logger.LogVerbose(logger.Green+"Finished copying ", humanize.Bytes(currentSize), " to ", toPath, logger.Reset)
 	return currentSize, err
 }
+
+// GetTotalFreeSpace returns the free space available to a set of bases, counting each volume once. Bases which
+// can't be read are skipped, unless none of them can be.
+func GetTotalFreeSpace(fs FileSystem, bases []string) (uint64, error) {
+	return sumVolumes(fs, bases, "free space", fs.GetFreeSpace)
+}
+
+// GetTotalCapacity returns the size of the volumes of a set of bases, counting each volume once like
+// GetTotalFreeSpace
+func GetTotalCapacity(fs FileSystem, bases []string) (uint64, error) {
+	return sumVolumes(fs, bases, "capacity", fs.GetCapacity)
+}
+
+func sumVolumes(fs FileSystem, bases []string, name string, get func(base string) (uint64, error)) (uint64, error) {
+	if len(bases) == 0 {
+		return 0, errors.New("no base directories")
+	}
+	var total uint64
+	var lastErr error
+	counted := make(map[string]bool)
+	for _, base := range bases {
+		volume, err := fs.GetVolume(base)
+		if err == nil && counted[volume] {
+			continue
+		}
+		var size uint64
+		if err == nil {
+			size, err = get(base)
+		}
+		if err != nil {
+			logger.LogVerbose("Could not read the ", name, " of ", base, ": ", err.Error())
+			lastErr = err
+			continue
+		}
+		counted[volume] = true
+		total += size
+	}
+	if len(counted) == 0 {
+		return 0, lastErr
+	}
+	return total, nil
+}
@@ -0,0 +1,178 @@
+package models
+
+import (
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"plex-go-sync/internal/filesystem"
+	"plex-go-sync/internal/logger"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Allocator splits the destination between the playlists of a run. A playlist with a size gets that size, or
+// that share of its volume for a size like 40%. The playlists without a size share the free space by weight.
+// Each playlist reserves what it may still copy, so playlists running at the same time don't count the same
+// free space twice. The playlists with a size reserve their whole size from the start, since their files on the
+// destination aren't known yet, and give back what their existing files already use once they are. Only the
+// space reserved on the same volumes counts against a playlist.
+type Allocator struct {
+	mutex    sync.Mutex
+	fs       filesystem.FileSystem
+	weights  map[string]float64         // playlists sharing the free space which have not been given their share yet
+	reserved map[string]int64           // space each playlist may still copy
+	volumes  map[string]map[string]bool // volumes each playlist copies to
+}
+
+// NewAllocator starts splitting the destination between playlists, reserving the size of each playlist with
+// one before any share of the free space is given out
+func NewAllocator(fs filesystem.FileSystem, playlists []Playlist) *Allocator {
+	allocator := &Allocator{
+		fs:       fs,
+		weights:  make(map[string]float64),
+		reserved: make(map[string]int64),
+		volumes:  make(map[string]map[string]bool),
+	}
+	for i := range playlists {
+		playlist := &playlists[i]
+		allocator.volumesOf(playlist)
+		if playlist.RawSize == "" {
+			allocator.weights[playlist.Name] = playlist.GetWeight()
+		} else if budget, err := allocator.fixedBudget(playlist); err == nil {
+			// a playlist with an invalid size is skipped when it runs, so it reserves nothing
+			allocator.reserved[playlist.Name] = budget
+		}
+	}
+	return allocator
+}
+
+// Budget returns the total size a playlist may use, including its existing files, and reserves what is left of
+// it to copy. It fails rather than returning a budget of 0 when the size is invalid or the space can't be read,
+// since the playlist would then remove all its copies.
+func (a *Allocator) Budget(p *Playlist, existing int64) (int64, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	var budget int64
+	if p.RawSize != "" {
+		var err error
+		if budget, err = a.fixedBudget(p); err != nil {
+			return 0, err
+		}
+	} else {
+		free, err := filesystem.GetTotalFreeSpace(a.fs, p.GetBases())
+		if err != nil {
+			return 0, err
+		}
+		// leave some space on the drive, and the space the other playlists are still copying to
+		available := int64(free) - paddingBytes - a.reservedByOthers(p)
+		if available < 0 {
+			available = 0
+		}
+		weight, ok := a.weights[p.Name]
+		if !ok {
+			weight = p.GetWeight()
+		}
+		total := weight
+		for name, other := range a.weights {
+			if name != p.Name {
+				total += other
+			}
+		}
+		delete(a.weights, p.Name)
+		budget = existing + int64(float64(available)*weight/total)
+	}
+
+	a.reserved[p.Name] = budget - existing
+	return budget, nil
+}
+
+// fixedBudget returns the size of a playlist with one, or that share of the capacity of its volumes
+func (a *Allocator) fixedBudget(p *Playlist) (int64, error) {
+	if !strings.HasSuffix(p.RawSize, "%") {
+		size, err := humanize.ParseBytes(p.RawSize)
+		if err != nil {
+			return 0, fmt.Errorf("invalid size %s for playlist %s: %w", p.RawSize, p.Name, err)
+		}
+		return int64(size), nil
+	}
+	percent, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(p.RawSize, "%")), 64)
+	if err != nil || percent <= 0 || percent > 100 {
+		return 0, fmt.Errorf("invalid size %s for playlist %s, expected a percentage such as 40%%", p.RawSize, p.Name)
+	}
+	capacity, err := filesystem.GetTotalCapacity(a.fs, p.GetBases())
+	if err != nil {
+		return 0, fmt.Errorf("could not get %s of the destination for playlist %s: %w", p.RawSize, p.Name, err)
+	}
+	return int64(float64(capacity) * percent / 100), nil
+}
+
+// Check lowers the remaining size of a playlist when there is less free space left for it, after the space the
+// other playlists reserved, and returns the remaining and total sizes
+func (a *Allocator) Check(p *Playlist, remaining int64, total int64) (int64, int64) {
+	free, err := filesystem.GetTotalFreeSpace(a.fs, p.GetBases())
+	if err != nil {
+		return remaining, total
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	available := int64(free) - a.reservedByOthers(p)
+	if available < 0 {
+		available = 0
+	}
+	if available < remaining {
+		logger.LogWarning("Not enough free space, adjusting remainingBytes from", humanize.Bytes(uint64(remaining)),
+			"to", humanize.Bytes(uint64(available)))
+		used := total - remaining
+		remaining = available - paddingBytes
+		if remaining < 0 {
+			remaining = 0
+		}
+		total = used + remaining
+	}
+	a.reserved[p.Name] = remaining
+	return remaining, total
+}
+
+// Release gives back the space a playlist reserved, once it has finished copying
+func (a *Allocator) Release(p *Playlist) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	delete(a.reserved, p.Name)
+}
+
+// reservedByOthers sums the space the other playlists reserved on any of the volumes of a playlist
+func (a *Allocator) reservedByOthers(p *Playlist) int64 {
+	volumes := a.volumesOf(p)
+	reserved := int64(0)
+	for name, size := range a.reserved {
+		if name == p.Name || size <= 0 {
+			continue
+		}
+		for volume := range a.volumes[name] {
+			if volumes[volume] {
+				reserved += size
+				break
+			}
+		}
+	}
+	return reserved
+}
+
+// volumesOf returns the volumes of the bases of a playlist. A base whose volume can't be read counts as its own.
+func (a *Allocator) volumesOf(p *Playlist) map[string]bool {
+	if volumes, ok := a.volumes[p.Name]; ok {
+		return volumes
+	}
+	volumes := make(map[string]bool)
+	for _, base := range p.GetBases() {
+		volume, err := a.fs.GetVolume(base)
+		if err != nil {
+			volume = base
+		}
+		volumes[volume] = true
+	}
+	a.volumes[p.Name] = volumes
+	return volumes
+}
@@ -0,0 +1,157 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"os"
+)
+
+// readCredentials overlays the fields of a credentials file on top of the config, so tokens can be kept out
+// of the main config file
+func readCredentials(path string, config *Config) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer file.Close()
+	return json.NewDecoder(file).Decode(config)
+}
+
+// ClientIdentifier returns the identifier which tells this install apart from others on plex.tv, as stored in
+// a config or credentials file. A file without one gets a new identifier, which WriteToken then stores.
+func ClientIdentifier(path string) (string, error) {
+	settings, err := readObject(path)
+	if err != nil {
+		return "", err
+	}
+	var identifier string
+	if raw, ok := settings.get("clientIdentifier"); ok {
+		_ = json.Unmarshal(raw, &identifier)
+	}
+	if identifier == "" {
+		identifier = uuid.New().String()
+	}
+	return identifier, nil
+}
+
+// WriteToken stores a token and the client identifier it was issued to in a config or credentials file,
+// keeping any other settings in the file as they are, in the same order. connection is one of "", "source"
+// or "destination", selecting the shared token or a per-server token.
+func WriteToken(path string, connection string, token string, clientIdentifier string) error {
+	settings, err := readObject(path)
+	if err != nil {
+		return err
+	}
+
+	identifier, err := json.Marshal(clientIdentifier)
+	if err != nil {
+		return err
+	}
+	settings = settings.set("clientIdentifier", identifier)
+	value, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	switch connection {
+	case "":
+		settings = settings.set("token", value)
+	case "source", "destination":
+		key := connection + "Connection"
+		var block jsonObject
+		if raw, ok := settings.get(key); ok && string(raw) != "null" {
+			if block, err = parseObject(raw); err != nil {
+				return err
+			}
+		}
+		settings = settings.set(key, block.set("token", value).marshal())
+	default:
+		return fmt.Errorf("unknown connection: %s", connection)
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, settings.marshal(), "", "  "); err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, out.Bytes(), 0600)
+}
+
+// readObject reads the settings of a config or credentials file, which may not exist yet
+func readObject(path string) (jsonObject, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return parseObject(data)
+}
+
+// jsonObject is a JSON object which keeps the order of its fields and the exact text of their values
+type jsonObject []jsonField
+
+type jsonField struct {
+	key   string
+	value json.RawMessage
+}
+
+func parseObject(data []byte) (jsonObject, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if token, err := decoder.Token(); err != nil {
+		return nil, err
+	} else if token != json.Delim('{') {
+		return nil, errors.New("expected a JSON object")
+	}
+	var object jsonObject
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		object = append(object, jsonField{key: token.(string), value: value})
+	}
+	return object, nil
+}
+
+func (o jsonObject) get(key string) (json.RawMessage, bool) {
+	for _, field := range o {
+		if field.key == key {
+			return field.value, true
+		}
+	}
+	return nil, false
+}
+
+// set replaces the value of a field, or adds the field at the end
+func (o jsonObject) set(key string, value json.RawMessage) jsonObject {
+	for i, field := range o {
+		if field.key == key {
+			o[i].value = value
+			return o
+		}
+	}
+	return append(o, jsonField{key: key, value: value})
+}
+
+func (o jsonObject) marshal() json.RawMessage {
+	var buffer bytes.Buffer
+	buffer.WriteByte('{')
+	for i, field := range o {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		key, _ := json.Marshal(field.key)
+		buffer.Write(key)
+		buffer.WriteByte(':')
+		buffer.Write(field.value)
+	}
+	buffer.WriteByte('}')
+	return buffer.Bytes()
+}
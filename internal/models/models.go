@@ -5,6 +5,7 @@ import (
 	"github.com/dustin/go-humanize"
 	"github.com/urfave/cli/v2"
 	"os"
+	"path/filepath"
 	"plex-go-sync/internal/filesystem"
 	"plex-go-sync/internal/logger"
 	. "plex-go-sync/internal/structures"
@@ -18,25 +19,101 @@ const widthFilter = 1280
 const crfFilter = 23
 const mediaFormat = "mp4"
 const paddingBytes = 500 * humanize.MiByte
+const audioFormat = "aac"
+const audioBitrate = "256k"
 
 type Config struct {
-	FastConvert       bool        `json:"-"`
-	Server            string      `json:"sourceServer"`
-	DestinationServer string      `json:"destinationServer"`
-	Token             string      `json:"token"`
-	Source            string      `json:"sourcePath"`
-	Destination       string      `json:"destinationPath"`
-	Playlists         []Playlist  `json:"playlists"`
-	MediaFormat       MediaFormat `json:"mediaFormat"`
+	FastConvert           bool        `json:"-"`
+	Path                  string      `json:"-"`       // the config file, which the history files are kept next to
+	TempDir               string      `json:"tempDir"` // local directory for temporary files, the system one if empty
+	Server                string      `json:"sourceServer"`
+	DestinationServer     string      `json:"destinationServer"`
+	Token                 string      `json:"token"`
+	Credentials           string      `json:"credentials"`
+	SourceConnection      Connection  `json:"sourceConnection"`
+	DestinationConnection Connection  `json:"destinationConnection"`
+	TwoWay                bool        `json:"twoWay"`
+	ConflictPolicy        string      `json:"conflictPolicy"`
+	Source                string      `json:"sourcePath"`
+	Destination           string      `json:"destinationPath"`
+	Playlists             []Playlist  `json:"playlists"`
+	Users                 []User      `json:"users"`
+	PageSize              int         `json:"pageSize"`
+	RecreatePlaylists     bool        `json:"recreatePlaylists"`
+	Rotate                bool        `json:"rotate"`      // remove watched items from the destination before cloning
+	RotateAfter           int         `json:"rotateAfter"` // days since an item was watched before it is rotated out
+	MediaFormat           MediaFormat `json:"mediaFormat"`
+	AudioFormat           AudioFormat `json:"audioFormat"`
+}
+
+// Connection holds the settings used to talk to a single Plex server. Empty fields fall back to the flat
+// sourceServer/destinationServer and token fields.
+type Connection struct {
+	URL                string    `json:"url"`
+	Name               string    `json:"name"` // friendly name or machine identifier, resolved with GDM when url is empty
+	Token              string    `json:"token"`
+	CACertificate      string    `json:"caCertificate"`      // path to a PEM bundle used to verify the server
+	InsecureSkipVerify bool      `json:"insecureSkipVerify"` // accept self-signed certificates
+	RawTimeout         string    `json:"timeout"`            // e.g. "30s"
+	PageSize           int       `json:"pageSize"`           // items fetched per request when paging
+	Retries            int       `json:"retries"`            // retries for transient failures, -1 to disable
+	Paths              PathRules `json:"paths"`              // rewrites the paths Plex reports to filesystem paths
+}
+
+// GetTimeout returns the request timeout, or 0 if the default should be used
+func (c Connection) GetTimeout() time.Duration {
+	timeout, err := time.ParseDuration(c.RawTimeout)
+	if err != nil {
+		return 0
+	}
+	return timeout
+}
+
+func (c *Connection) fallback(url string, token string, pageSize int) {
+	if c.URL == "" {
+		c.URL = url
+	}
+	if c.Token == "" {
+		c.Token = token
+	}
+	if c.PageSize == 0 {
+		c.PageSize = pageSize
+	}
 }
 
 type Playlist struct {
-	Clean   bool                     `json:"clean"`
-	Name    string                   `json:"name"`
-	RawSize string                   `json:"size"`
-	Size    int64                    `json:"-"`
-	Base    string                   `json:"-"`
-	Items   OrderedMap[PlaylistItem] `json:"items"`
+	Clean           bool                     `json:"clean"`
+	Name            string                   `json:"name"`
+	RawSize         string                   `json:"size"`            // e.g. 100G, or 40% of the destination volume
+	Weight          float64                  `json:"weight"`          // share of the free space among the playlists without a size
+	Order           string                   `json:"order"`           // which items are copied first, see selection
+	Seed            int64                    `json:"seed"`            // seeds the random choices of the order, 0 for a new seed each run
+	EpisodesPerShow int                      `json:"episodesPerShow"` // episodes of each show kept by the next-unwatched order
+	Pack            string                   `json:"pack"`            // priority, hours or items to choose the items which fit best
+	Quotas          []Quota                  `json:"quotas"`          // limits on how much of the playlist a show, genre or rating takes
+	Size            int64                    `json:"-"`
+	Bases           []string                 `json:"-"`
+	Items           OrderedMap[PlaylistItem] `json:"items"`
+}
+
+// Quota limits the items or size each show, genre or content rating may take up in a playlist
+type Quota struct {
+	By      string `json:"by"`    // show, genre or contentRating
+	Items   int    `json:"items"` // max items of each show, genre or rating, 0 for no limit
+	RawSize string `json:"size"`  // max size of each show, genre or rating, e.g. 20G
+}
+
+// GetSize returns the size limit of the quota, 0 for no limit
+func (q Quota) GetSize() int64 {
+	size, _ := humanize.ParseBytes(q.RawSize)
+	return int64(size)
+}
+
+// User is a Plex Home or managed user whose play state is synced separately from the admin account
+type User struct {
+	Name             string `json:"name"`
+	SourceToken      string `json:"sourceToken"`
+	DestinationToken string `json:"destinationToken"`
 }
 
 type MediaFormat struct {
@@ -47,9 +124,57 @@ type MediaFormat struct {
 	Format        string `json:"format"`
 }
 
+// AudioFormat is the format music tracks are transcoded to
+type AudioFormat struct {
+	Format  string `json:"format"`  // aac, opus, mp3 or flac
+	Bitrate string `json:"bitrate"` // e.g. 256k
+}
+
+// Codec returns the ffmpeg encoder for the format
+func (f AudioFormat) Codec() string {
+	switch f.Format {
+	case "opus":
+		return "libopus"
+	case "mp3":
+		return "libmp3lame"
+	}
+	return f.Format
+}
+
+// Extension returns the file extension for the format
+func (f AudioFormat) Extension() string {
+	if f.Format == "aac" {
+		return "m4a"
+	}
+	return f.Format
+}
+
+// Muxer returns the ffmpeg container format for the format
+func (f AudioFormat) Muxer() string {
+	if f.Format == "aac" {
+		return "ipod"
+	}
+	return f.Format
+}
+
+// GetBitrate returns the bitrate in bits per second
+func (f AudioFormat) GetBitrate() int {
+	bitrate, err := humanize.ParseBytes(strings.TrimSuffix(strings.ToLower(f.Bitrate), "bps"))
+	if err != nil {
+		return 0
+	}
+	// humanize treats k as 1000 bytes, which is the same multiplier used for bitrates
+	return int(bitrate)
+}
+
+// DataFile returns the path of a file kept next to the config file, such as the copy history
+func (c *Config) DataFile(name string) string {
+	return filepath.Join(filepath.Dir(c.Path), name)
+}
+
 func ReadConfig(ctx *cli.Context) (*Config, error) {
 	path := ctx.Path("config")
-	var config Config
+	config := Config{Path: path}
 	logger.LogInfo("Loading config...")
 	file, err := os.Open(path)
 	if err != nil {
@@ -59,16 +184,32 @@ func ReadConfig(ctx *cli.Context) (*Config, error) {
 	if err := decoder.Decode(&config); err != nil {
 		return nil, err
 	}
+	if config.Credentials != "" {
+		if err := readCredentials(config.Credentials, &config); err != nil {
+			return nil, err
+		}
+	}
 
 	if ctx.String("server") != "" {
 		config.Server = ctx.String("server")
+		config.SourceConnection.URL = ""
 	}
 	if ctx.String("token") != "" {
 		config.Token = ctx.String("token")
+		config.SourceConnection.Token = ""
+		config.DestinationConnection.Token = ""
 	}
 	if ctx.String("destination-server") != "" {
 		config.DestinationServer = ctx.String("destination-server")
+		config.DestinationConnection.URL = ""
 	}
+	if ctx.Int("page-size") > 0 {
+		config.PageSize = ctx.Int("page-size")
+	}
+	config.SourceConnection.fallback(config.Server, config.Token, config.PageSize)
+	config.DestinationConnection.fallback(config.DestinationServer, config.Token, config.PageSize)
+	config.Server = config.SourceConnection.URL
+	config.DestinationServer = config.DestinationConnection.URL
 	if ctx.StringSlice("playlist") != nil && len(ctx.StringSlice("playlist")) > 0 {
 		for i := 0; i < len(ctx.StringSlice("playlist")); i++ {
 			config.Playlists = append(config.Playlists, *NewPlaylist(ctx.StringSlice("playlist")[i], ctx.StringSlice("size")[i]))
@@ -76,6 +217,21 @@ func ReadConfig(ctx *cli.Context) (*Config, error) {
 	}
 
 	config.FastConvert = ctx.Bool("fast")
+	if ctx.Bool("recreate-playlists") {
+		config.RecreatePlaylists = true
+	}
+	if ctx.Bool("rotate") {
+		config.Rotate = true
+	}
+	if ctx.Int("rotate-after") > 0 {
+		config.RotateAfter = ctx.Int("rotate-after")
+	}
+	if ctx.Bool("two-way") {
+		config.TwoWay = true
+	}
+	if ctx.String("conflict-policy") != "" {
+		config.ConflictPolicy = ctx.String("conflict-policy")
+	}
 
 	if config.MediaFormat.Format == "" {
 		config.MediaFormat.Format = mediaFormat
@@ -92,22 +248,51 @@ func ReadConfig(ctx *cli.Context) (*Config, error) {
 	if config.MediaFormat.WidthFilter == 0 {
 		config.MediaFormat.WidthFilter = widthFilter
 	}
+	if config.AudioFormat.Format == "" {
+		config.AudioFormat.Format = audioFormat
+	}
+	if config.AudioFormat.Bitrate == "" {
+		config.AudioFormat.Bitrate = audioBitrate
+	}
 
 	return &config, err
 }
 
+// GetExtension returns the extension of the cloned file for an item
+func (c *Config) GetExtension(item PlaylistItem) string {
+	if item.Type == "track" {
+		return c.AudioFormat.Extension()
+	}
+	return c.MediaFormat.Format
+}
+
 func NewPlaylist(name string, rawSize string) *Playlist {
 	size, _ := humanize.ParseBytes(rawSize)
 	return &Playlist{Name: name, RawSize: rawSize, Size: int64(size)}
 }
 
-// GetBase get the base directory of the playlist
-func (p *Playlist) GetBase() string {
-	if p.Base == "" {
-		paths := p.Items.Front().Value.Paths
-		p.Base, _, _ = strings.Cut(strings.TrimLeft(paths[len(paths)-1], "/"), "/")
+// GetBases get the base directories of the playlist, the first folder of each item path in the order they
+// are first seen, e.g. movies and movies-4k
+func (p *Playlist) GetBases() []string {
+	if p.Bases == nil {
+		seen := make(map[string]bool)
+		for item := p.Items.Front(); item != nil; item = item.Next() {
+			for _, path := range item.Value.Paths {
+				base := GetBase(path)
+				if !seen[base] {
+					seen[base] = true
+					p.Bases = append(p.Bases, base)
+				}
+			}
+		}
 	}
-	return p.Base
+	return p.Bases
+}
+
+// GetBase returns the first folder of a path, which is the share or directory under the library root
+func GetBase(path string) string {
+	base, _, _ := strings.Cut(strings.TrimLeft(path, "/"), "/")
+	return base
 }
 
 func (p *Playlist) GetSize() int64 {
@@ -118,34 +303,63 @@ func (p *Playlist) GetSize() int64 {
 	return p.Size
 }
 
-// GetTotalSize get the size of the existing files in the playlist plus the remaining free space
-// OR the size limit of the destination config
-func (p *Playlist) GetTotalSize(root string, existing int64) int64 {
-	if p.RawSize == "" {
-		fs := filesystem.NewFileSystem(root)
-		size, err := fs.GetFreeSpace(p.GetBase())
-		if err != nil {
-			return 0
-		}
-		// leave some space on the drive
-		return int64(size) - paddingBytes + existing
+// GetWeight returns the share of the free space the playlist gets, relative to the other playlists without a
+// size
+func (p *Playlist) GetWeight() float64 {
+	if p.Weight <= 0 {
+		return 1
 	}
-	size, _ := humanize.ParseBytes(p.RawSize)
-	return int64(size)
+	return p.Weight
 }
 
 type PlaylistItem struct {
-	Paths    []string      `json:"paths"`
-	Parent   string        `json:"parent"`
-	Duration time.Duration `json:"duration"`
+	Paths     []string            `json:"paths"`
+	Parts     map[string][]string `json:"parts,omitempty"` // the files joined into each multi-part path
+	Type      string              `json:"type"`            // movie, episode or track
+	Parent    string              `json:"parent"`
+	ParentKey string              `json:"parentKey,omitempty"` // rating key of the show or artist
+	Duration  time.Duration       `json:"duration"`
+	Size      int64               `json:"size,omitempty"`    // size of the source media as reported by Plex
+	Bitrate   int                 `json:"bitrate,omitempty"` // bitrate of the source media in kbps
+	Height    int                 `json:"height,omitempty"`
+	Container string              `json:"container,omitempty"`
+	Codec     string              `json:"codec,omitempty"` // audio codec of the source media
+
+	// the play state and details used to order the playlist
+	RatingKey    string  `json:"ratingKey,omitempty"`
+	Season       int     `json:"season,omitempty"`
+	Episode      int     `json:"episode,omitempty"`
+	ViewCount    int     `json:"viewCount,omitempty"`
+	AddedAt      int     `json:"addedAt,omitempty"`
+	LastViewedAt int     `json:"lastViewedAt,omitempty"`
+	Rating       float64 `json:"rating,omitempty"`
+
+	// the categories used by the quotas of the playlist
+	ContentRating string   `json:"contentRating,omitempty"`
+	Genres        []string `json:"genres,omitempty"`
+}
+
+// GetParts returns the files of a path, which is the path itself unless the media has several parts
+func (p PlaylistItem) GetParts(path string) []string {
+	if parts, ok := p.Parts[path]; ok {
+		return parts
+	}
+	return []string{path}
 }
 
+// GetSize returns the size of the first path which exists, adding up the parts of multi-part media
 func (p PlaylistItem) GetSize(f filesystem.FileSystem) uint64 {
+pathLoop:
 	for _, item := range p.Paths {
-		size, err := f.GetSize(item)
-		if err == nil {
-			return size
+		total := uint64(0)
+		for _, part := range p.GetParts(item) {
+			size, err := f.GetSize(part)
+			if err != nil {
+				continue pathLoop
+			}
+			total += size
 		}
+		return total
 	}
 	return 0
 }
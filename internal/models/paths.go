@@ -0,0 +1,85 @@
+package models
+
+import (
+	"strings"
+)
+
+// PathRule rewrites the start of a path as Plex reports it to the same path in the filesystem, e.g. a server
+// which sees /mnt/storage/tv while the share is //nas/tv needs {"from": "/mnt/storage/tv", "to": "/tv"}
+type PathRule struct {
+	From string `json:"from"` // prefix of the path reported by Plex
+	To   string `json:"to"`   // prefix of the path in sourcePath or destinationPath, starting with the share name
+}
+
+// PathRules are the rules of one server. Paths without a matching rule are used as they are, which means
+// the first folder of the Plex path has to be the share name.
+type PathRules []PathRule
+
+// Map rewrites a path reported by Plex to a filesystem path, using the rule with the longest matching prefix
+func (r PathRules) Map(p string) string {
+	mapped, _ := r.rewrite(p, false)
+	return mapped
+}
+
+// Unmap rewrites a filesystem path back to the path Plex reports, returning false if no rule matched
+func (r PathRules) Unmap(p string) (string, bool) {
+	mapped, rule := r.rewrite(p, true)
+	return mapped, rule >= 0
+}
+
+// Find returns the rule Map uses for a path reported by Plex
+func (r PathRules) Find(p string) (PathRule, bool) {
+	_, rule := r.rewrite(p, false)
+	if rule < 0 {
+		return PathRule{}, false
+	}
+	return r[rule], true
+}
+
+// rewrite applies the rule with the longest matching prefix and returns its index, or -1 if none matched
+func (r PathRules) rewrite(p string, reverse bool) (string, int) {
+	best := -1
+	longest := -1
+	result := p
+	for i, rule := range r {
+		from, to := rule.From, rule.To
+		if reverse {
+			from, to = to, from
+		}
+		from = strings.TrimRight(from, `/\`)
+		if len(from) <= longest {
+			continue
+		}
+		if mapped, ok := replacePrefix(p, from, to); ok {
+			best = i
+			longest = len(from)
+			result = mapped
+		}
+	}
+	return result, best
+}
+
+// replacePrefix swaps a folder prefix, converting separators when one side is a Windows path
+func replacePrefix(p string, from string, to string) (string, bool) {
+	sep := separator(from)
+	if p != from && !strings.HasPrefix(p, from+sep) {
+		return "", false
+	}
+	rest := strings.TrimPrefix(p[len(from):], sep)
+	toSep := separator(to)
+	to = strings.TrimRight(to, `/\`)
+	if rest == "" {
+		if to == "" {
+			return toSep, true
+		}
+		return to, true
+	}
+	return to + toSep + strings.ReplaceAll(rest, sep, toSep), true
+}
+
+func separator(p string) string {
+	if strings.Contains(p, `\`) {
+		return `\`
+	}
+	return "/"
+}
@@ -2,6 +2,8 @@ package models
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 )
 
 func IsDone(ctx *context.Context) bool {
@@ -16,3 +18,26 @@ func IsDone(ctx *context.Context) bool {
 func GetConfig(ctx *context.Context) *Config {
 	return (*ctx).Value("config").(*Config)
 }
+
+// WriteFileAtomic writes data to a temporary file next to path and renames it over path, so an interrupted run
+// never leaves a truncated file behind
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(file.Name())
+	}()
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Chmod(perm)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
@@ -0,0 +1,203 @@
+package plex
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"plex-go-sync/internal/logger"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const DefaultRetries = 3
+const retryBaseDelay = 500 * time.Millisecond
+const retryMaxDelay = 30 * time.Second
+
+// StatusError is returned for any response outside the 2xx range
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Method, e.URL, e.Status)
+}
+
+// Temporary reports whether the same request may succeed later
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// IsStatus returns true if err is a StatusError with the given status code
+func IsStatus(err error, code int) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == code
+}
+
+func IsNotFound(err error) bool {
+	return IsStatus(err, http.StatusNotFound)
+}
+
+func IsUnauthorized(err error) bool {
+	return IsStatus(err, http.StatusUnauthorized) || IsStatus(err, http.StatusForbidden)
+}
+
+// doRequest sends a request, retrying transient failures with jittered exponential backoff. Requests which
+// aren't idempotent are only retried when the server cannot have acted on them. Responses outside the 2xx
+// range are returned as a StatusError, otherwise the caller must close the body.
+func doRequest(ctx *context.Context, client *http.Client, verb string, query string, headers http.Header, retries int,
+	idempotent bool) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := sendRequest(ctx, client, verb, query, headers)
+		if err == nil {
+			return resp, nil
+		}
+		if attempt >= retries || (*ctx).Err() != nil || !isTransient(idempotent, err) {
+			return nil, err
+		}
+		delay := backoff(attempt, err)
+		logger.LogVerbose("Retrying", verb, query, "in", delay, ":", err.Error())
+		select {
+		case <-time.After(delay):
+		case <-(*ctx).Done():
+			return nil, (*ctx).Err()
+		}
+	}
+}
+
+func sendRequest(ctx *context.Context, client *http.Client, verb string, query string, headers http.Header) (*http.Response, error) {
+	logger.LogVerbose(verb, query)
+	req, err := http.NewRequestWithContext(*ctx, verb, query, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+
+	// drain the body so the connection can be reused
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	statusErr := &StatusError{Method: verb, URL: req.URL.Redacted(), StatusCode: resp.StatusCode, Status: resp.Status}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		statusErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return nil, statusErr
+}
+
+// isTransient decides if a failed request is worth retrying. A request which isn't idempotent, such as a POST
+// or a PUT adding items to a playlist, is only retried when the server cannot have acted on it, so nothing is
+// created or added twice.
+func isTransient(idempotent bool, err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		if !idempotent {
+			return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode == http.StatusServiceUnavailable
+		}
+		return statusErr.Temporary()
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	if !idempotent {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// backoff returns the delay before the next attempt, honouring Retry-After when the server sends it
+func backoff(attempt int, err error) time.Duration {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		if statusErr.RetryAfter > retryMaxDelay {
+			return retryMaxDelay
+		}
+		return statusErr.RetryAfter
+	}
+	delay := retryBaseDelay << attempt
+	if delay > retryMaxDelay || delay <= 0 {
+		delay = retryMaxDelay
+	}
+	// full jitter between half and the whole delay, so parallel requests don't retry in lockstep
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// request sends an authenticated request to the server
+func (p *Server) request(ctx *context.Context, verb string, query string, headers map[string]string) (*http.Response, error) {
+	return p.send(ctx, verb, query, headers, verb != http.MethodPost)
+}
+
+// send sends an authenticated request, retrying it as far as is safe for an idempotent request or not
+func (p *Server) send(ctx *context.Context, verb string, query string, headers map[string]string,
+	idempotent bool) (*http.Response, error) {
+	header := http.Header{}
+	header.Add("Accept", p.Headers.Accept)
+	header.Add("X-Plex-Platform", p.Headers.Platform)
+	header.Add("X-Plex-Platform-Version", p.Headers.PlatformVersion)
+	header.Add("X-Plex-Provides", p.Headers.Provides)
+	header.Add("X-Plex-Client-Identifier", p.ClientIdentifier)
+	header.Add("X-Plex-Product", p.Headers.Product)
+	header.Add("X-Plex-Version", p.Headers.Version)
+	header.Add("X-Plex-Device", p.Headers.Device)
+	header.Add("X-Plex-Token", p.Token)
+
+	// optional headers
+	if p.Headers.TargetClientIdentifier != "" {
+		header.Add("X-Plex-Target-Identifier", p.Headers.TargetClientIdentifier)
+	}
+	for key, value := range headers {
+		header.Add(key, value)
+	}
+
+	retries := p.Retries
+	if retries == 0 {
+		retries = DefaultRetries
+	}
+	return doRequest(ctx, &p.HTTPClient, verb, query, header, retries, idempotent)
+}
+
+// do sends a request whose response body isn't needed
+func (p *Server) do(ctx *context.Context, verb string, query string) error {
+	resp, err := p.request(ctx, verb, query, nil)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+// getJSON decodes the response to a GET request into result
+func (p *Server) getJSON(ctx *context.Context, query string, headers map[string]string, result interface{}) error {
+	resp, err := p.request(ctx, "GET", query, headers)
+	if err != nil {
+		return err
+	}
+
+	//goland:noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
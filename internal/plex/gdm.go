@@ -0,0 +1,150 @@
+package plex
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"plex-go-sync/internal/logger"
+	"plex-go-sync/internal/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GDM (G'Day Mate) is the UDP discovery protocol Plex servers answer on the local network
+const gdmPort = 32414
+const gdmMulticast = "239.0.0.250"
+const gdmSearch = "M-SEARCH * HTTP/1.1\r\n\r\n"
+const DefaultDiscoveryTimeout = 3 * time.Second
+
+type DiscoveredServer struct {
+	Name              string
+	MachineIdentifier string
+	Address           string
+	Port              int
+	Version           string
+}
+
+func (s DiscoveredServer) URL() string {
+	return fmt.Sprintf("http://%s", net.JoinHostPort(s.Address, strconv.Itoa(s.Port)))
+}
+
+// Matches returns true if name is the friendly name or machine identifier of the server
+func (s DiscoveredServer) Matches(name string) bool {
+	return strings.EqualFold(s.Name, name) || s.MachineIdentifier == name
+}
+
+// Discover sends a GDM search to the multicast group and the broadcast address, and collects every server
+// that answers before the timeout
+func Discover(timeout time.Duration) ([]DiscoveredServer, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer conn.Close()
+
+	sent := false
+	for _, ip := range []string{gdmMulticast, net.IPv4bcast.String()} {
+		addr := &net.UDPAddr{IP: net.ParseIP(ip), Port: gdmPort}
+		if _, err = conn.WriteToUDP([]byte(gdmSearch), addr); err != nil {
+			logger.LogVerbose("GDM search to", addr, "failed:", err.Error())
+			continue
+		}
+		sent = true
+	}
+	if !sent {
+		return nil, err
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	var servers []DiscoveredServer
+	seen := make(map[string]bool)
+	buf := make([]byte, 4096)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// the read deadline ends the search
+			break
+		}
+		server, ok := ParseGDMResponse(buf[:n], from)
+		if !ok || seen[server.MachineIdentifier+server.URL()] {
+			continue
+		}
+		seen[server.MachineIdentifier+server.URL()] = true
+		logger.LogVerbose("Discovered", server.Name, "at", server.URL())
+		servers = append(servers, server)
+	}
+	return servers, nil
+}
+
+// ParseGDMResponse reads the HTTP-style reply of a server, e.g.
+// HTTP/1.0 200 OK\r\nContent-Type: plex/media-server\r\nName: pi\r\nPort: 32400\r\nResource-Identifier: abc\r\n
+func ParseGDMResponse(data []byte, from *net.UDPAddr) (DiscoveredServer, bool) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return DiscoveredServer{}, false
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" && contentType != "plex/media-server" {
+		return DiscoveredServer{}, false
+	}
+	port, err := strconv.Atoi(resp.Header.Get("Port"))
+	if err != nil {
+		port = 32400
+	}
+	return DiscoveredServer{
+		Name:              resp.Header.Get("Name"),
+		MachineIdentifier: resp.Header.Get("Resource-Identifier"),
+		Address:           from.IP.String(),
+		Port:              port,
+		Version:           resp.Header.Get("Version"),
+	}, true
+}
+
+// ResolveServers replaces server names in the config with the address found by GDM discovery. A server is
+// looked up when its connection has a name and no url, or when the url has no scheme.
+func ResolveServers(config *models.Config) error {
+	connections := []*models.Connection{&config.SourceConnection, &config.DestinationConnection}
+	var servers []DiscoveredServer
+	discovered := false
+
+	for _, conn := range connections {
+		if conn.URL != "" && !strings.Contains(conn.URL, "://") {
+			conn.Name = conn.URL
+			conn.URL = ""
+		}
+		if conn.URL != "" || conn.Name == "" {
+			continue
+		}
+		if !discovered {
+			logger.LogInfo("Discovering Plex servers...")
+			var err error
+			if servers, err = Discover(DefaultDiscoveryTimeout); err != nil {
+				return err
+			}
+			discovered = true
+		}
+		for _, server := range servers {
+			if server.Matches(conn.Name) {
+				conn.URL = server.URL()
+				logger.LogInfo("Resolved", conn.Name, "to", conn.URL)
+				break
+			}
+		}
+		if conn.URL == "" {
+			return fmt.Errorf("could not find server %s on the local network", conn.Name)
+		}
+	}
+
+	config.Server = config.SourceConnection.URL
+	config.DestinationServer = config.DestinationConnection.URL
+	return nil
+}
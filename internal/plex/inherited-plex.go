@@ -1,6 +1,9 @@
 package plex
 
-import "github.com/jrudio/go-plex-client"
+import (
+	"encoding/json"
+	"github.com/jrudio/go-plex-client"
+)
 
 type PlaylistContainer struct {
 	MediaContainer PlaylistMediaContainer `json:"MediaContainer"`
@@ -8,23 +11,43 @@ type PlaylistContainer struct {
 
 type PlaylistMediaContainer struct {
 	plex.MediaContainer
-	Playlist []Playlist `json:"Playlist"`
+	Playlist []Playlist `json:"Metadata"` // shadows the embedded Metadata list
 }
 
 type Playlist struct {
-	RatingKey    int    `json:"ratingKey"`
-	Key          string `json:"key"`
-	GUID         string `json:"guid"`
-	Title        string `json:"title"`
-	Type         string `json:"type"`
-	Summary      string `json:"summary"`
-	Smart        int    `json:"smart"`
-	PlaylistType string `json:"playlistType"`
-	Composite    string `json:"composite"`
-	Icon         string `json:"icon"`
-	Content      string `json:"content"`
-	Duration     int    `json:"duration"`
-	LeafCount    int    `json:"leafCount"`
-	AddedAt      int    `json:"addedAt"`
-	UpdatedAt    int    `json:"updatedAt"`
+	RatingKey    string    `json:"ratingKey"`
+	Key          string    `json:"key"`
+	GUID         string    `json:"guid"`
+	Title        string    `json:"title"`
+	Type         string    `json:"type"`
+	Summary      string    `json:"summary"`
+	Smart        IntOrBool `json:"smart"`
+	PlaylistType string    `json:"playlistType"`
+	Composite    string    `json:"composite"`
+	Icon         string    `json:"icon"`
+	Content      string    `json:"content"`
+	Duration     int       `json:"duration"`
+	LeafCount    int       `json:"leafCount"`
+	AddedAt      int       `json:"addedAt"`
+	UpdatedAt    int       `json:"updatedAt"`
+}
+
+// IntOrBool decodes fields which Plex returns either as 0/1 or as false/true
+type IntOrBool int
+
+func (i *IntOrBool) UnmarshalJSON(data []byte) error {
+	var b bool
+	if err := json.Unmarshal(data, &b); err == nil {
+		*i = 0
+		if b {
+			*i = 1
+		}
+		return nil
+	}
+	var n int
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*i = IntOrBool(n)
+	return nil
 }
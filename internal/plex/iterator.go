@@ -0,0 +1,183 @@
+package plex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jrudio/go-plex-client"
+	"strconv"
+)
+
+const DefaultPageSize = 500
+
+type pageContainer struct {
+	MediaContainer struct {
+		plex.MediaContainer
+		TotalSize *int `json:"totalSize"` // missing from some responses
+	} `json:"MediaContainer"`
+}
+
+// MetadataIterator pages through a Plex container with X-Plex-Container-Start/X-Plex-Container-Size, so
+// large libraries and playlists are never fetched in a single response.
+//
+//	it := server.IterateLibraryContent(ctx, key, "")
+//	for it.Next() {
+//		item := it.Item()
+//	}
+//	if err := it.Err(); err != nil {
+type MetadataIterator struct {
+	ctx      *context.Context
+	server   *Server
+	query    string
+	pageSize int
+	start    int
+	total    int
+	page     []plex.Metadata
+	index    int
+	err      error
+}
+
+// Iterate returns an iterator over the Metadata of the container at query
+func (p *Server) Iterate(ctx *context.Context, query string) *MetadataIterator {
+	pageSize := p.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	return &MetadataIterator{ctx: ctx, server: p, query: query, pageSize: pageSize, total: -1}
+}
+
+// Next advances to the next item, fetching the next page when needed. It returns false when there are no
+// more items or a request failed.
+func (it *MetadataIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	it.index++
+	if it.index < len(it.page) {
+		return true
+	}
+	if it.total >= 0 && it.start >= it.total {
+		return false
+	}
+	if it.err = it.fetch(); it.err != nil {
+		return false
+	}
+	it.index = 0
+	return len(it.page) > 0
+}
+
+// Item returns the current item
+func (it *MetadataIterator) Item() plex.Metadata {
+	return it.page[it.index]
+}
+
+// Err returns the error which stopped the iteration, if any
+func (it *MetadataIterator) Err() error {
+	return it.err
+}
+
+// TotalSize returns the number of items in the container, or -1 while it is not known
+func (it *MetadataIterator) TotalSize() int {
+	return it.total
+}
+
+// All collects the remaining items
+func (it *MetadataIterator) All() ([]plex.Metadata, error) {
+	var items []plex.Metadata
+	for it.Next() {
+		items = append(items, it.Item())
+	}
+	return items, it.Err()
+}
+
+func (it *MetadataIterator) fetch() error {
+	var page pageContainer
+	err := it.server.getJSON(it.ctx, it.query, map[string]string{
+		"X-Plex-Container-Start": strconv.Itoa(it.start),
+		"X-Plex-Container-Size":  strconv.Itoa(it.pageSize),
+	}, &page)
+	if err != nil {
+		return err
+	}
+	it.page = page.MediaContainer.Metadata
+	it.start += len(it.page)
+	if page.MediaContainer.TotalSize != nil {
+		it.total = *page.MediaContainer.TotalSize
+	}
+	if len(it.page) != it.pageSize {
+		// a short page is the last one, and servers which ignore paging return everything at once
+		it.total = it.start
+	}
+	return nil
+}
+
+// IterateLibraryContent pages through the content of a library section, including external guids
+func (p *Server) IterateLibraryContent(ctx *context.Context, sectionKey string, filter string) *MetadataIterator {
+	return p.Iterate(ctx, fmt.Sprintf("%s/library/sections/%s/all%s", p.URL, sectionKey, filter))
+}
+
+// IterateAllLeaves pages through every episode of a show, including external guids
+func (p *Server) IterateAllLeaves(ctx *context.Context, key string) *MetadataIterator {
+	return p.Iterate(ctx, fmt.Sprintf("%s/library/metadata/%s/allLeaves?includeGuids=1", p.URL, key))
+}
+
+// IteratePlaylistItems pages through the items of a playlist, evaluating smart playlists against the library
+func (p *Server) IteratePlaylistItems(ctx *context.Context, name string) (*MetadataIterator, error) {
+	playlists, err := p.GetPlaylistsByName(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(playlists) == 0 {
+		return nil, errors.New("no playlist found")
+	}
+	// the title filter also matches partial names, so prefer an exact match
+	playlist := playlists[0]
+	for _, candidate := range playlists {
+		if candidate.Title == name {
+			playlist = candidate
+			break
+		}
+	}
+
+	if playlist.Smart == 1 {
+		filter, err := SmartPlaylistQuery(playlist.Content)
+		if err != nil {
+			return nil, err
+		}
+		return p.Iterate(ctx, p.URL+filter), nil
+	}
+	return p.Iterate(ctx, fmt.Sprintf("%s/playlists/%s/items", p.URL, playlist.RatingKey)), nil
+}
+
+// GetItem returns the metadata of a single library item, including external guids
+func (p *Server) GetItem(ctx *context.Context, ratingKey string) (plex.Metadata, error) {
+	items, err := p.Iterate(ctx, fmt.Sprintf("%s/library/metadata/%s?includeGuids=1", p.URL, ratingKey)).All()
+	if err != nil {
+		return plex.Metadata{}, err
+	}
+	if len(items) == 0 {
+		return plex.Metadata{}, fmt.Errorf("item %s not found", ratingKey)
+	}
+	return items[0], nil
+}
+
+// GetGenres returns the genres of a movie, show or artist, which the metadata of playlist items doesn't include
+func (p *Server) GetGenres(ctx *context.Context, ratingKey string) ([]string, error) {
+	var result struct {
+		MediaContainer struct {
+			Metadata []struct {
+				Genre []plex.TaggedData `json:"Genre"`
+			} `json:"Metadata"`
+		} `json:"MediaContainer"`
+	}
+	if err := p.getJSON(ctx, fmt.Sprintf("%s/library/metadata/%s", p.URL, ratingKey), nil, &result); err != nil {
+		return nil, err
+	}
+	var genres []string
+	for _, metadata := range result.MediaContainer.Metadata {
+		for _, genre := range metadata.Genre {
+			genres = append(genres, genre.Tag)
+		}
+	}
+	return genres, nil
+}
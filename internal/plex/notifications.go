@@ -0,0 +1,196 @@
+package plex
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/jrudio/go-plex-client"
+	"net/http"
+	"net/url"
+	"plex-go-sync/internal/logger"
+	"time"
+)
+
+const scanActivity = "library.update.section"
+
+// Notification is a message from the server's websocket notification stream
+type Notification struct {
+	Type       string                 `json:"type"`
+	Activities []ActivityNotification `json:"ActivityNotification"`
+	Timeline   []plex.TimelineEntry   `json:"TimelineEntry"`
+}
+
+// ActivityNotification reports the progress of a background task, such as a library scan
+type ActivityNotification struct {
+	Event    string `json:"event"` // started, updated or ended
+	Activity struct {
+		Type    string `json:"type"`
+		Title   string `json:"title"`
+		Context struct {
+			LibrarySectionID json.Number `json:"librarySectionID"`
+		} `json:"Context"`
+	} `json:"Activity"`
+}
+
+// Notifications subscribes to /:/websockets/notifications. The channel is closed when the context is done
+// or the connection drops.
+func (p *Server) Notifications(ctx *context.Context) (<-chan Notification, error) {
+	wsURL, err := url.Parse(p.URL + "/:/websockets/notifications")
+	if err != nil {
+		return nil, err
+	}
+	if wsURL.Scheme == "https" {
+		wsURL.Scheme = "wss"
+	} else {
+		wsURL.Scheme = "ws"
+	}
+
+	dialer := *websocket.DefaultDialer
+	if transport, ok := p.HTTPClient.Transport.(*http.Transport); ok {
+		dialer.TLSClientConfig = transport.TLSClientConfig
+	}
+	headers := http.Header{}
+	headers.Add("X-Plex-Token", p.Token)
+	headers.Add("X-Plex-Client-Identifier", p.ClientIdentifier)
+
+	logger.LogVerbose("Connecting to", wsURL.Redacted())
+	conn, resp, err := dialer.DialContext(*ctx, wsURL.String(), headers)
+	if err != nil {
+		if resp != nil {
+			return nil, &StatusError{Method: "GET", URL: wsURL.Redacted(), StatusCode: resp.StatusCode, Status: resp.Status}
+		}
+		return nil, err
+	}
+
+	notifications := make(chan Notification)
+	go func() {
+		<-(*ctx).Done()
+		_ = conn.Close()
+	}()
+	go func() {
+		defer close(notifications)
+		//goland:noinspection GoUnhandledErrorResult
+		defer conn.Close()
+		for {
+			var message struct {
+				Notification Notification `json:"NotificationContainer"`
+			}
+			if err := conn.ReadJSON(&message); err != nil {
+				if (*ctx).Err() == nil {
+					logger.LogVerbose("Notification stream closed: ", err.Error())
+				}
+				return
+			}
+			select {
+			case notifications <- message.Notification:
+			case <-(*ctx).Done():
+				return
+			}
+		}
+	}()
+	return notifications, nil
+}
+
+// scanTracker follows the library scans awaited by RefreshLibraries or WaitForScans and reports each section once it is done
+type scanTracker struct {
+	server     *Server
+	results    chan<- string
+	refreshing map[string]bool   // sections seen scanning
+	finished   map[string]bool   // sections already reported
+	titles     map[string]string // section titles, for logging
+}
+
+// watch follows scan activity on the notification stream. Sections are reported as soon as their scan ends,
+// and once nothing has happened for the quiet period, any section which isn't scanning is reported too. It
+// returns false if the stream dropped before all scans finished.
+func (t *scanTracker) watch(ctx *context.Context, notifications <-chan Notification, quiet time.Duration) bool {
+	timer := time.NewTimer(quiet)
+	defer timer.Stop()
+	for {
+		select {
+		case notification, ok := <-notifications:
+			if !ok {
+				return false
+			}
+			for _, activity := range notification.Activities {
+				if activity.Activity.Type != scanActivity {
+					continue
+				}
+				key := activity.Activity.Context.LibrarySectionID.String()
+				if activity.Event == "ended" {
+					t.report(key)
+				} else {
+					t.refreshing[key] = true
+				}
+			}
+			if len(notification.Activities) > 0 || len(notification.Timeline) > 0 {
+				timer.Reset(quiet)
+			}
+		case <-timer.C:
+			done, err := t.check(ctx)
+			if err != nil || done {
+				return true
+			}
+			timer.Reset(quiet)
+		case <-(*ctx).Done():
+			return true
+		}
+	}
+}
+
+// poll checks the sections on an interval, for servers where the notification stream isn't available
+func (t *scanTracker) poll(ctx *context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			done, err := t.check(ctx)
+			if err != nil || done {
+				return
+			}
+		case <-(*ctx).Done():
+			return
+		}
+	}
+}
+
+// check reports the sections which were scanning and have finished. Once no section is scanning it reports
+// the rest, whose scans were too quick to be seen, and returns true.
+func (t *scanTracker) check(ctx *context.Context) (bool, error) {
+	libraries, err := t.server.GetLibraries(ctx)
+	if err != nil {
+		return false, err
+	}
+	refreshing := false
+	for _, library := range libraries.MediaContainer.Directory {
+		t.titles[library.Key] = library.Title
+		if library.Refreshing {
+			t.refreshing[library.Key] = true
+			refreshing = true
+		} else if t.refreshing[library.Key] {
+			t.report(library.Key)
+		}
+	}
+	if refreshing {
+		return false, nil
+	}
+	for _, library := range libraries.MediaContainer.Directory {
+		t.report(library.Key)
+	}
+	return true, nil
+}
+
+func (t *scanTracker) report(key string) {
+	if key == "" || t.finished[key] {
+		return
+	}
+	t.finished[key] = true
+	t.refreshing[key] = false
+	title := t.titles[key]
+	if title == "" {
+		title = key
+	}
+	logger.LogInfo("Library ", title, " is done refreshing")
+	t.results <- key
+}
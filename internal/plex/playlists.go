@@ -0,0 +1,177 @@
+package plex
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"plex-go-sync/internal/logger"
+	"strings"
+)
+
+// playlistChunkSize limits how many items are sent in one request, to keep the uri a sane length
+const playlistChunkSize = 100
+
+// GetMachineIdentifier returns the identifier of the server, which is needed to build library uris
+func (p *Server) GetMachineIdentifier(ctx *context.Context) (string, error) {
+	var identity struct {
+		MediaContainer struct {
+			MachineIdentifier string `json:"machineIdentifier"`
+		} `json:"MediaContainer"`
+	}
+	if err := p.getJSON(ctx, p.URL+"/identity", nil, &identity); err != nil {
+		return "", err
+	}
+	return identity.MediaContainer.MachineIdentifier, nil
+}
+
+// ReplacePlaylist creates a regular playlist of the given type (video or audio) with the items in order, or if a
+// playlist with the same title already exists, replaces its items in place. The old items are only removed once
+// all the new ones are in, so a failure part way leaves the playlist as it was.
+func (p *Server) ReplacePlaylist(ctx *context.Context, title string, playlistType string, ratingKeys []string) error {
+	if len(ratingKeys) == 0 {
+		return errors.New("playlist has no items")
+	}
+	machineID, err := p.GetMachineIdentifier(ctx)
+	if err != nil {
+		return err
+	}
+
+	playlists, err := p.GetPlaylistsByName(ctx, title)
+	if err != nil {
+		return err
+	}
+	playlistKey := ""
+	for _, playlist := range playlists {
+		if playlist.Title == title && playlist.Smart == 0 {
+			playlistKey = playlist.RatingKey
+			break
+		}
+	}
+
+	if playlistKey == "" {
+		logger.LogInfo("Creating playlist", title)
+		end := len(ratingKeys)
+		if end > playlistChunkSize {
+			end = playlistChunkSize
+		}
+		if playlistKey, err = p.createPlaylist(ctx, title, playlistType, libraryURI(machineID, ratingKeys[:end])); err != nil {
+			return err
+		}
+		if err := p.addToPlaylist(ctx, playlistKey, machineID, ratingKeys[end:]); err != nil {
+			// don't leave a partial playlist behind
+			if deleteErr := p.deletePlaylist(ctx, playlistKey); deleteErr != nil {
+				logger.LogWarning("Error removing the partial playlist", title, ":", deleteErr.Error())
+			}
+			return err
+		}
+		return nil
+	}
+
+	logger.LogInfo("Updating playlist", title)
+	oldEntries, err := p.getPlaylistEntries(ctx, playlistKey)
+	if err != nil {
+		return err
+	}
+	if err := p.addToPlaylist(ctx, playlistKey, machineID, ratingKeys); err != nil {
+		// take out what was added, leaving the old items
+		entries, listErr := p.getPlaylistEntries(ctx, playlistKey)
+		if listErr == nil {
+			listErr = p.removeFromPlaylist(ctx, playlistKey, entries[len(oldEntries):])
+		}
+		if listErr != nil {
+			logger.LogWarning("Error removing the new items from playlist", title, ":", listErr.Error())
+		}
+		return err
+	}
+	return p.removeFromPlaylist(ctx, playlistKey, oldEntries)
+}
+
+// addToPlaylist appends items to a playlist in chunks. Appending twice would add the items twice, so a request
+// is not retried once the server may have acted on it.
+func (p *Server) addToPlaylist(ctx *context.Context, playlistKey string, machineID string, ratingKeys []string) error {
+	for start := 0; start < len(ratingKeys); start += playlistChunkSize {
+		end := start + playlistChunkSize
+		if end > len(ratingKeys) {
+			end = len(ratingKeys)
+		}
+		uri := libraryURI(machineID, ratingKeys[start:end])
+		query := fmt.Sprintf("%s/playlists/%s/items?uri=%s", p.URL, playlistKey, url.QueryEscape(uri))
+		resp, err := p.send(ctx, "PUT", query, nil, false)
+		if err != nil {
+			return err
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		if err := resp.Body.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getPlaylistEntries returns the playlist item id of each entry of a playlist, in order. Unlike the rating key,
+// it tells apart the same item added twice.
+func (p *Server) getPlaylistEntries(ctx *context.Context, playlistKey string) ([]string, error) {
+	var results struct {
+		MediaContainer struct {
+			Metadata []struct {
+				PlaylistItemID json.Number `json:"playlistItemID"`
+			} `json:"Metadata"`
+		} `json:"MediaContainer"`
+	}
+	if err := p.getJSON(ctx, fmt.Sprintf("%s/playlists/%s/items", p.URL, playlistKey), nil, &results); err != nil {
+		return nil, err
+	}
+	entries := make([]string, len(results.MediaContainer.Metadata))
+	for i, entry := range results.MediaContainer.Metadata {
+		entries[i] = entry.PlaylistItemID.String()
+	}
+	return entries, nil
+}
+
+// removeFromPlaylist removes entries of a playlist by their playlist item id. An entry which is already gone,
+// such as after a retried request, is skipped.
+func (p *Server) removeFromPlaylist(ctx *context.Context, playlistKey string, entries []string) error {
+	for _, entry := range entries {
+		err := p.do(ctx, "DELETE", fmt.Sprintf("%s/playlists/%s/items/%s", p.URL, playlistKey, entry))
+		if err != nil && !IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Server) deletePlaylist(ctx *context.Context, playlistKey string) error {
+	return p.do(ctx, "DELETE", fmt.Sprintf("%s/playlists/%s", p.URL, playlistKey))
+}
+
+func (p *Server) createPlaylist(ctx *context.Context, title string, playlistType string, uri string) (string, error) {
+	args := url.Values{}
+	args.Set("type", playlistType)
+	args.Set("title", title)
+	args.Set("smart", "0")
+	args.Set("uri", uri)
+	resp, err := p.request(ctx, "POST", fmt.Sprintf("%s/playlists?%s", p.URL, args.Encode()), nil)
+	if err != nil {
+		return "", err
+	}
+
+	//goland:noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
+
+	var results PlaylistContainer
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return "", err
+	}
+	if len(results.MediaContainer.Playlist) == 0 {
+		return "", errors.New("playlist was not created")
+	}
+	return results.MediaContainer.Playlist[0].RatingKey, nil
+}
+
+// libraryURI builds the uri Plex uses to refer to a list of library items
+func libraryURI(machineID string, ratingKeys []string) string {
+	return fmt.Sprintf("server://%s/%s/library/metadata/%s", machineID, libraryIdentifier, strings.Join(ratingKeys, ","))
+}
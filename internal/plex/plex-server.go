@@ -2,22 +2,28 @@ package plex
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"github.com/jrudio/go-plex-client"
+	"golang.org/x/exp/slices"
 	"net/http"
 	"net/url"
+	"os"
 	"path"
 	"plex-go-sync/internal/logger"
 	"plex-go-sync/internal/models"
-	"strconv"
+	"regexp"
 	"strings"
 	"time"
 )
 
 type Server struct {
 	plex.Plex
+	PageSize int
+	Retries  int              // attempts after the first for transient failures, negative to disable
+	Paths    models.PathRules // maps the file paths reported by the server to filesystem paths
 }
 
 // New creates a new plex instance that is required
@@ -27,129 +33,120 @@ func New(baseURL string, token string) (*Server, error) {
 	return &Server{Plex: *server}, err
 }
 
-// GetPlaylistsByName GetPlaylists returns a list of results from the Plex server
-func (p *Server) GetPlaylistsByName(title string) (plex.SearchResults, error) {
-	args := make(map[string]string)
-	args["title"] = title
-
-	query := fmt.Sprintf("%s/playlists%s", p.URL, joinArgs(args))
-
-	resp, err := p.http("GET", query)
-
+// Connect creates a new plex instance using the connection settings of a single server
+func Connect(conn models.Connection) (*Server, error) {
+	server, err := New(conn.URL, conn.Token)
 	if err != nil {
-		return plex.SearchResults{}, err
+		return server, err
 	}
 
-	// Unauthorized
-	if resp.Status == plex.ErrorInvalidToken {
-		return plex.SearchResults{}, errors.New("invalid token")
-	}
-	if resp.StatusCode == http.StatusUnauthorized {
-		return plex.SearchResults{}, errors.New(plex.ErrorNotAuthorized)
+	tlsConfig := &tls.Config{InsecureSkipVerify: conn.InsecureSkipVerify}
+	if conn.CACertificate != "" {
+		pem, err := os.ReadFile(conn.CACertificate)
+		if err != nil {
+			return server, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return server, fmt.Errorf("no certificates found in %s", conn.CACertificate)
+		}
+		tlsConfig.RootCAs = pool
 	}
-	if resp.StatusCode != http.StatusOK {
-		return plex.SearchResults{}, fmt.Errorf(plex.ErrorServer, resp.Status)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	server.HTTPClient.Transport = transport
+	server.DownloadClient.Transport = transport
+
+	if timeout := conn.GetTimeout(); timeout > 0 {
+		server.HTTPClient.Timeout = timeout
 	}
+	server.PageSize = conn.PageSize
+	server.Retries = conn.Retries
+	server.Paths = conn.Paths
+	return server, nil
+}
 
-	//goland:noinspection GoUnhandledErrorResult
-	defer resp.Body.Close()
+// GetLibraries returns the library sections of the server
+func (p *Server) GetLibraries(ctx *context.Context) (plex.LibrarySections, error) {
+	var libraries plex.LibrarySections
+	err := p.getJSON(ctx, p.URL+"/library/sections", nil, &libraries)
+	return libraries, err
+}
 
-	var results plex.SearchResults
+// GetPlaylistsByName GetPlaylists returns a list of results from the Plex server
+func (p *Server) GetPlaylistsByName(ctx *context.Context, title string) ([]Playlist, error) {
+	args := make(map[string]string)
+	args["title"] = title
 
-	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
-		return plex.SearchResults{}, err
+	query := fmt.Sprintf("%s/playlists%s", p.URL, joinArgs(args))
+
+	var results PlaylistContainer
+	if err := p.getJSON(ctx, query, nil, &results); err != nil {
+		return nil, err
 	}
 
-	return results, nil
+	return results.MediaContainer.Playlist, nil
 }
 
-func (p *Server) RefreshLibrary(id int, path string) error {
-	query := fmt.Sprintf("%s/library/sections/%d/refresh?path=%s", p.URL, id, url.QueryEscape(path))
-	if _, err := p.http("GET", query); err != nil {
-		return err
-	}
-	return nil
+// RefreshLibrary starts a partial scan of one folder of a library section
+func (p *Server) RefreshLibrary(ctx *context.Context, sectionKey string, path string) error {
+	query := fmt.Sprintf("%s/library/sections/%s/refresh?path=%s", p.URL, sectionKey, url.QueryEscape(path))
+	return p.do(ctx, "GET", query)
 }
 
+// RefreshLibraries scans every library section and sends the key of each section once its scan has
+// finished. Scan progress comes from the notification stream, or from polling if it can't be opened.
 func (p *Server) RefreshLibraries(ctx *context.Context) chan string {
+	return p.trackScans(ctx, true)
+}
+
+// WaitForScans sends the key of each library section once the scans already running, such as the partial
+// scans sent by a ScanBatcher, have finished. No new scan is started.
+func (p *Server) WaitForScans(ctx *context.Context) chan string {
+	return p.trackScans(ctx, false)
+}
+
+func (p *Server) trackScans(ctx *context.Context, refresh bool) chan string {
 	results := make(chan string)
-	query := fmt.Sprintf("%s/library/sections/all/refresh", p.URL)
-	if _, err := p.http("GET", query); err != nil {
-		close(results)
-		return results
+	watchCtx, cancel := context.WithTimeout(*ctx, time.Hour*1)
+	// subscribe first, so the start of the scans isn't missed
+	notifications, err := p.Notifications(&watchCtx)
+	if err != nil {
+		logger.LogVerbose("Notifications unavailable, polling for scan progress: ", err.Error())
+	}
+
+	if refresh {
+		query := fmt.Sprintf("%s/library/sections/all/refresh", p.URL)
+		if err := p.do(ctx, "GET", query); err != nil {
+			logger.LogWarning("Could not refresh libraries: ", err.Error())
+			cancel()
+			close(results)
+			return results
+		}
 	}
 
 	go func() {
-		timeout := time.After(time.Hour * 1)
-		ticker := time.NewTicker(time.Second * 30)
-		defer ticker.Stop()
 		defer close(results)
-		libMap := make(map[string]bool)
-
-		for {
-			select {
-			case <-ticker.C:
-				refreshing := false
-				libraries, err := p.GetLibraries()
-				if err != nil {
-					return
-				}
-				for _, library := range libraries.MediaContainer.Directory {
-					if libMap[library.Key] && !library.Refreshing {
-						logger.LogInfo("Library ", library.Title, " is done refreshing")
-						results <- library.Key
-					}
-					libMap[library.Key] = library.Refreshing
-					refreshing = refreshing || library.Refreshing
-				}
-				if !refreshing {
-					return
-				}
-			case <-(*ctx).Done():
-				return
-			case <-timeout:
-				return
-			}
+		defer cancel()
+		tracker := &scanTracker{
+			server:     p,
+			results:    results,
+			refreshing: make(map[string]bool),
+			finished:   make(map[string]bool),
+			titles:     make(map[string]string),
+		}
+		if notifications != nil && tracker.watch(&watchCtx, notifications, time.Second*30) {
+			return
 		}
+		tracker.poll(&watchCtx, time.Second*30)
 	}()
 
 	return results
 }
 
-func (p *Server) http(verb string, query string) (*http.Response, error) {
-	client := p.HTTPClient
-
-	logger.LogVerbose(verb, query)
-	req, reqErr := http.NewRequest(verb, query, nil)
-
-	if reqErr != nil {
-		return &http.Response{}, reqErr
-	}
-
-	req.Header.Add("Accept", p.Headers.Accept)
-	req.Header.Add("X-Plex-Platform", p.Headers.Platform)
-	req.Header.Add("X-Plex-Platform-Version", p.Headers.PlatformVersion)
-	req.Header.Add("X-Plex-Provides", p.Headers.Provides)
-	req.Header.Add("X-Plex-Client-Identifier", p.ClientIdentifier)
-	req.Header.Add("X-Plex-Product", p.Headers.Product)
-	req.Header.Add("X-Plex-Version", p.Headers.Version)
-	req.Header.Add("X-Plex-Device", p.Headers.Device)
-	req.Header.Add("X-Plex-Token", p.Token)
-
-	// optional headers
-	if p.Headers.TargetClientIdentifier != "" {
-		req.Header.Add("X-Plex-Target-Identifier", p.Headers.TargetClientIdentifier)
-	}
-
-	resp, err := client.Do(req)
-
-	if err != nil {
-		return &http.Response{}, err
-	}
-
-	return resp, nil
-}
-
 // joinArgs Returns a query string (uses for HTTP URLs) where only the value is URL encoded.
 // Example return value: '?genre=action&type=1337'.
 // Parameters:
@@ -162,118 +159,122 @@ func joinArgs(args map[string]string) string {
 	return "?" + strings.Join(argList, "&")
 }
 
-func (p *Server) EditMetadata(librarySectionID string, args map[string]string) error {
+func (p *Server) EditMetadata(ctx *context.Context, librarySectionID string, args map[string]string) error {
 	query := fmt.Sprintf("%s/library/sections/%s/all%s", p.URL, librarySectionID, joinArgs(args))
-	resp, err := p.http("PUT", query)
-	if err != nil {
-		return err
-	}
-	// Unauthorized
-	if resp.StatusCode == http.StatusUnauthorized {
-		return errors.New(plex.ErrorNotAuthorized)
-	} else if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
-		return fmt.Errorf(plex.ErrorServer, resp.Status)
-	}
-	return errors.New(resp.Status)
+	return p.do(ctx, "PUT", query)
 }
 
-func (p *Server) SyncWatched(src plex.Metadata, dest plex.Metadata) {
-	doUpdate := false
-	updates := map[string]string{
-		"id":   src.RatingKey,
-		"type": src.Type,
-	}
-	viewCount, _ := src.ViewCount.Int64()
-	srcViewCount, _ := dest.ViewCount.Int64()
-	if (viewCount == 0) && (srcViewCount > 0) {
-		updates["viewCount"] = dest.ViewCount.String()
-		doUpdate = true
-	}
-	if dest.ViewOffset > 0 {
-		updates["viewOffset"] = strconv.Itoa(dest.ViewOffset)
-		doUpdate = true
-	}
-	if doUpdate {
-		_ = p.EditMetadata(src.LibrarySectionKey, updates)
-	}
-}
-
-func (p *Server) GetLibrarySectionByName(name string, filter string) (plex.SearchResults, string, error) {
-	libraries, err := p.GetLibraries()
+// GetLibrarySection returns the library section with the given key or title
+func (p *Server) GetLibrarySection(ctx *context.Context, keyOrTitle string) (plex.Directory, error) {
+	libraries, err := p.GetLibraries(ctx)
 	if err != nil {
-		return plex.SearchResults{}, "", err
+		return plex.Directory{}, err
 	}
-	key := ""
-	libType := ""
 	for _, library := range libraries.MediaContainer.Directory {
-		if name == library.Title {
-			key = library.Key
-			libType = library.Type
+		if keyOrTitle == library.Key || keyOrTitle == library.Title {
+			return library, nil
 		}
 	}
-	if key == "" {
-		return plex.SearchResults{}, "", errors.New("library section not found")
-	}
-	content, err := p.GetLibraryContent(key, filter)
-	return content, libType, err
+	return plex.Directory{}, errors.New("library section not found")
 }
 
-func (p *Server) GetPlaylistItems(name string) (plex.SearchResultsEpisode, error) {
-	playlist, err := p.GetPlaylistsByName(name)
-	if err != nil {
-		return plex.SearchResultsEpisode{}, err
-	}
-	if len(playlist.MediaContainer.Metadata) == 0 {
-		return plex.SearchResultsEpisode{}, errors.New("no playlist found")
+// SmartPlaylistQuery extracts the library query from the content uri of a smart playlist, e.g.
+// library://<uuid>/directory/%2Flibrary%2Fsections%2F1%2Fall%3Ftype%3D4%26unwatched%3D1
+func SmartPlaylistQuery(content string) (string, error) {
+	_, directory, found := strings.Cut(content, "/directory/")
+	if !found || directory == "" {
+		return "", fmt.Errorf("unsupported smart playlist content: %s", content)
 	}
-	playlistKey, err := strconv.Atoi(playlist.MediaContainer.Metadata[0].RatingKey)
+	query, err := url.PathUnescape(directory)
 	if err != nil {
-		return plex.SearchResultsEpisode{}, err
+		return "", err
 	}
-	items, err := p.GetPlaylist(playlistKey)
-	if err != nil {
-		return plex.SearchResultsEpisode{}, err
+	if !strings.HasPrefix(query, "/") {
+		query = "/" + query
 	}
-	return items, nil
+	return query, nil
 }
 
-func GetMediaPath(ctx *context.Context, item plex.Metadata, baseDir string) ([]string, time.Duration) {
+// multi-part files follow the Plex stacking convention, e.g. "Movie - pt1.mkv" or "Movie - cd2.avi"
+var stackSuffix = regexp.MustCompile(`(?i)[ ._-]*(cd|dvd|part|pt|disc|disk)[ ._-]*\d+$`)
+
+// StackedPath returns the path the parts of a multi-part media are joined into, which is the first part
+// without its stacking suffix
+func StackedPath(parts []string) string {
+	first := parts[0]
+	ext := path.Ext(first)
+	name := stackSuffix.ReplaceAllString(strings.TrimSuffix(first, ext), "")
+	if name == "" || strings.HasSuffix(name, "/") {
+		return first
+	}
+	return name + ext
+}
+
+// GetMediaPath returns the filesystem paths of the best media for the item, and for multi-part media, the files
+// which make up each path. The paths reported by the source server are rewritten with its path rules. When bases
+// is set, only media in one of those base directories is considered.
+func GetMediaPath(ctx *context.Context, item plex.Metadata, bases []string) ([]string, map[string][]string, time.Duration) {
 	config := models.GetConfig(ctx)
+	rules := config.SourceConnection.Paths
 	// find 720p if exists
 	bestMedia := item.Media[0]
 	for _, media := range item.Media {
-		if len(media.Part) != 1 {
+		if len(media.Part) == 0 {
 			continue
 		}
-		if baseDir != "" {
-			var base, _, _ = strings.Cut(strings.TrimLeft(media.Part[0].File, "/"), "/")
-			if base != baseDir {
-				continue
-			}
+		if len(bases) > 0 && !slices.Contains(bases, models.GetBase(rules.Map(media.Part[0].File))) {
+			continue
 		}
 
 		if media.Height <= config.MediaFormat.HeightFilter &&
-			(bestMedia.Height > config.MediaFormat.HeightFilter || media.Height > bestMedia.Height) &&
-			len(media.Part) == 1 {
+			(bestMedia.Height > config.MediaFormat.HeightFilter || media.Height > bestMedia.Height) {
 			bestMedia = media
 		}
 	}
 
-	if len(bestMedia.Part) != 1 {
-		// multipart files - not supported yet
-		return []string{}, -1
+	if len(bestMedia.Part) == 0 {
+		return []string{}, nil, -1
 	}
 
 	// Eventually we should just sort the media by best match and then return all the items, but for now we
 	// just return up to two items
+	parts := make(map[string][]string)
 	mediaPaths := []string{
-		bestMedia.Part[0].File,
+		mediaPath(bestMedia, rules, parts),
 	}
-	if bestMedia.Part[0].File != item.Media[0].Part[0].File {
-		mediaPaths = append(mediaPaths, item.Media[0].Part[0].File)
+	if len(item.Media[0].Part) > 0 {
+		if fallback := mediaPath(item.Media[0], rules, parts); fallback != mediaPaths[0] {
+			mediaPaths = append(mediaPaths, fallback)
+		}
 	}
 
-	return mediaPaths, time.Duration(bestMedia.Duration) * time.Millisecond
+	// the duration of the media covers all of its parts
+	return mediaPaths, parts, time.Duration(bestMedia.Duration) * time.Millisecond
+}
+
+// GetMedia returns the media of the item at a path returned by GetMediaPath
+func GetMedia(ctx *context.Context, item plex.Metadata, mediaFile string) (plex.Media, bool) {
+	rules := models.GetConfig(ctx).SourceConnection.Paths
+	for _, media := range item.Media {
+		if len(media.Part) > 0 && mediaPath(media, rules, make(map[string][]string)) == mediaFile {
+			return media, true
+		}
+	}
+	return plex.Media{}, false
+}
+
+// mediaPath returns the file of a media, or the stacked path of a multi-part media after recording its parts
+func mediaPath(media plex.Media, rules models.PathRules, parts map[string][]string) string {
+	if len(media.Part) == 1 {
+		return rules.Map(media.Part[0].File)
+	}
+	files := make([]string, len(media.Part))
+	for i, part := range media.Part {
+		files[i] = rules.Map(part.File)
+	}
+	stacked := StackedPath(files)
+	parts[stacked] = files
+	return stacked
 }
 
 func GetKey(mediaPath string) string {
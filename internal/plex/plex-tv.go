@@ -0,0 +1,98 @@
+package plex
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const DefaultPlexTvURL = "https://plex.tv"
+const DefaultLinkURL = "https://plex.tv/link"
+const product = "Plex Go Sync"
+
+// Pin is a code the user enters at the link url to authorize this app
+type Pin struct {
+	ID        int    `json:"id"`
+	Code      string `json:"code"`
+	ExpiresIn int    `json:"expiresIn"`
+	AuthToken string `json:"authToken"`
+}
+
+// PinClient runs the plex.tv PIN link flow against a configurable base url. The token is issued to
+// ClientIdentifier, so each install needs its own.
+type PinClient struct {
+	BaseURL          string
+	ClientIdentifier string
+	HTTPClient       http.Client
+}
+
+func NewPinClient(baseURL string, clientIdentifier string) *PinClient {
+	if baseURL == "" {
+		baseURL = DefaultPlexTvURL
+	}
+	return &PinClient{
+		BaseURL:          strings.TrimSuffix(baseURL, "/"),
+		ClientIdentifier: clientIdentifier,
+		HTTPClient:       http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// RequestPin asks plex.tv for a new link code
+func (c *PinClient) RequestPin(ctx *context.Context) (Pin, error) {
+	return c.pinRequest(ctx, "POST", c.BaseURL+"/api/v2/pins")
+}
+
+// CheckPin returns the current state of a pin. AuthToken is empty until the user has linked the code.
+func (c *PinClient) CheckPin(ctx *context.Context, id int) (Pin, error) {
+	return c.pinRequest(ctx, "GET", fmt.Sprintf("%s/api/v2/pins/%d", c.BaseURL, id))
+}
+
+// WaitForToken polls the pin until it is authorized, it expires, or the context is cancelled
+func (c *PinClient) WaitForToken(ctx *context.Context, pin Pin, interval time.Duration) (string, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	expires := time.After(time.Duration(pin.ExpiresIn) * time.Second)
+	for {
+		select {
+		case <-ticker.C:
+			checked, err := c.CheckPin(ctx, pin.ID)
+			if err != nil {
+				return "", err
+			}
+			if checked.AuthToken != "" {
+				return checked.AuthToken, nil
+			}
+		case <-expires:
+			return "", errors.New("pin expired before it was linked")
+		case <-(*ctx).Done():
+			return "", (*ctx).Err()
+		}
+	}
+}
+
+func (c *PinClient) pinRequest(ctx *context.Context, verb string, query string) (Pin, error) {
+	headers := http.Header{}
+	headers.Add("Accept", "application/json")
+	headers.Add("X-Plex-Client-Identifier", c.ClientIdentifier)
+	headers.Add("X-Plex-Product", product)
+	headers.Add("X-Plex-Platform", runtime.GOOS)
+	headers.Add("X-Plex-Device", runtime.GOOS+" "+runtime.GOARCH)
+
+	resp, err := doRequest(ctx, &c.HTTPClient, verb, query, headers, DefaultRetries, verb != http.MethodPost)
+	if err != nil {
+		return Pin{}, err
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
+
+	var pin Pin
+	if err := json.NewDecoder(resp.Body).Decode(&pin); err != nil {
+		return Pin{}, err
+	}
+	return pin, nil
+}
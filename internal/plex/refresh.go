@@ -0,0 +1,154 @@
+package plex
+
+import (
+	"context"
+	"github.com/jrudio/go-plex-client"
+	"path"
+	"plex-go-sync/internal/logger"
+	"plex-go-sync/internal/models"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultScanDelay = 30 * time.Second
+
+// ScanBatcher requests partial scans of the folders new files were copied to. Scans are delayed so that
+// several files landing in the same folder, like the episodes of a season, only trigger one scan.
+type ScanBatcher struct {
+	ctx      *context.Context
+	server   *Server
+	delay    time.Duration
+	sections []plex.Directory
+	mutex    sync.Mutex
+	pending  map[string]string // folder to section key
+	timer    *time.Timer
+	sent     bool
+}
+
+// NewScanBatcher loads the library locations of the server, which are used to find the section of each file
+func (p *Server) NewScanBatcher(ctx *context.Context, delay time.Duration) (*ScanBatcher, error) {
+	libraries, err := p.GetLibraries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &ScanBatcher{
+		ctx:      ctx,
+		server:   p,
+		delay:    delay,
+		sections: libraries.MediaContainer.Directory,
+		pending:  make(map[string]string),
+	}, nil
+}
+
+// Add queues a scan of the folder containing file, a path relative to the media root such as
+// movies/Movie (2020)/Movie (2020).mp4
+func (b *ScanBatcher) Add(file string) {
+	if serverPath, ok := b.server.Paths.Unmap("/" + strings.TrimPrefix(file, "/")); ok {
+		file = serverPath
+	}
+	sectionKey, folder, found := FindSectionFolder(b.sections, file)
+	if !found {
+		logger.LogVerbose("No library section contains", file)
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.pending[folder] = sectionKey
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.delay, b.Flush)
+	}
+}
+
+// Flush sends the queued scans immediately
+func (b *ScanBatcher) Flush() {
+	b.mutex.Lock()
+	pending := b.pending
+	b.pending = make(map[string]string)
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.mutex.Unlock()
+
+	for folder, sectionKey := range pending {
+		logger.LogVerbose("Scanning", folder)
+		if err := b.server.RefreshLibrary(b.ctx, sectionKey, folder); err != nil {
+			logger.LogWarning("Could not scan ", folder, ": ", err.Error())
+			continue
+		}
+		b.mutex.Lock()
+		b.sent = true
+		b.mutex.Unlock()
+	}
+}
+
+// Sent reports whether any scan was sent, so the new files are already being scanned
+func (b *ScanBatcher) Sent() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.sent
+}
+
+// FindSectionFolder returns the section and server folder for a file. A file already mapped to a server path
+// belongs to the section with a location containing it. Otherwise the file is relative to the media root and
+// its first folder is matched against the last folder of each section location, so movies/Movie/Movie.mp4
+// belongs to a section with the location /mnt/media/movies.
+func FindSectionFolder(sections []plex.Directory, file string) (string, string, bool) {
+	for _, section := range sections {
+		for _, location := range section.Location {
+			root := strings.TrimRight(location.Path, "/\\")
+			sep := "/"
+			if strings.Contains(root, "\\") {
+				sep = "\\"
+			}
+			if root != "" && strings.HasPrefix(file, root+sep) {
+				return section.Key, file[:strings.LastIndex(file, sep)], true
+			}
+		}
+	}
+
+	base, rest, found := strings.Cut(strings.TrimPrefix(file, "/"), "/")
+	if !found {
+		return "", "", false
+	}
+	for _, section := range sections {
+		for _, location := range section.Location {
+			// locations on Windows servers use backslashes
+			root := strings.TrimRight(location.Path, "/\\")
+			if root[strings.LastIndexAny(root, "/\\")+1:] != base {
+				continue
+			}
+			folder := path.Join(root, path.Dir(rest))
+			if strings.Contains(root, "\\") {
+				folder = strings.ReplaceAll(folder, "/", "\\")
+			}
+			return section.Key, folder, true
+		}
+	}
+	return "", "", false
+}
+
+// FindLocalFile is the reverse of FindSectionFolder, returning the path under the media root of a file the
+// server reports. Files without a matching path rule are found by the last folder of the section location
+// containing them, so /mnt/media/movies/Movie/Movie.mp4 is movies/Movie/Movie.mp4.
+func FindLocalFile(sections []plex.Directory, paths models.PathRules, file string) (string, bool) {
+	if _, found := paths.Find(file); found {
+		return paths.Map(file), true
+	}
+	for _, section := range sections {
+		for _, location := range section.Location {
+			root := strings.TrimRight(location.Path, "/\\")
+			sep := "/"
+			if strings.Contains(root, "\\") {
+				sep = "\\"
+			}
+			if root != "" && strings.HasPrefix(file, root+sep) {
+				rest := strings.ReplaceAll(file[len(root)+1:], sep, "/")
+				return "/" + root[strings.LastIndexAny(root, "/\\")+1:] + "/" + rest, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,114 @@
+package plex
+
+import (
+	"context"
+	"fmt"
+	"github.com/jrudio/go-plex-client"
+	"net/url"
+	"strconv"
+)
+
+// TrackType is the Plex metadata type of music tracks, used to list them with ?type=10
+const TrackType = 10
+
+// Rating is the star rating a user gave an item, from 1 to 10
+type Rating struct {
+	UserRating  float64 `json:"userRating"`
+	LastRatedAt int     `json:"lastRatedAt"`
+}
+
+// GetRatings returns the ratings of the rated items of a type in a section, by rating key
+func (p *Server) GetRatings(ctx *context.Context, sectionKey string, itemType int) (map[string]Rating, error) {
+	pageSize := p.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	// servers which don't know the filter return every item, which is handled the same way
+	query := fmt.Sprintf("%s/library/sections/%s/all?type=%d&userRating>>=1", p.URL, sectionKey, itemType)
+	ratings := make(map[string]Rating)
+	for start := 0; ; start += pageSize {
+		var page struct {
+			MediaContainer struct {
+				TotalSize int `json:"totalSize"`
+				Metadata  []struct {
+					Rating
+					RatingKey string `json:"ratingKey"`
+				} `json:"Metadata"`
+			} `json:"MediaContainer"`
+		}
+		err := p.getJSON(ctx, query, map[string]string{
+			"X-Plex-Container-Start": strconv.Itoa(start),
+			"X-Plex-Container-Size":  strconv.Itoa(pageSize),
+		}, &page)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.MediaContainer.Metadata {
+			if item.UserRating > 0 {
+				ratings[item.RatingKey] = item.Rating
+			}
+		}
+		if len(page.MediaContainer.Metadata) < pageSize || start+pageSize >= page.MediaContainer.TotalSize {
+			return ratings, nil
+		}
+	}
+}
+
+// SetRating rates an item on this server
+func (p *Server) SetRating(ctx *context.Context, ratingKey string, rating float64) error {
+	args := url.Values{}
+	args.Set("key", ratingKey)
+	args.Set("identifier", libraryIdentifier)
+	args.Set("rating", strconv.FormatFloat(rating, 'f', -1, 64))
+	return p.do(ctx, "PUT", fmt.Sprintf("%s/:/rate?%s", p.URL, args.Encode()))
+}
+
+// SyncTrack copies the play count and rating of a music track. Play counts only ever go up, so when syncing
+// both ways the lower count is raised to the higher one, while ratings follow the conflict policy.
+func SyncTrack(ctx *context.Context, source *Server, src plex.Metadata, srcRating Rating, dest *Server,
+	destItem plex.Metadata, destRating Rating, twoWay bool, policy ConflictPolicy) (Direction, error) {
+	direction := NoChange
+	srcCount, _ := src.ViewCount.Int64()
+	destCount, _ := destItem.ViewCount.Int64()
+	if srcCount > destCount {
+		if err := dest.addPlays(ctx, destItem.RatingKey, srcCount-destCount); err != nil {
+			return ToDestination, err
+		}
+		direction = ToDestination
+	} else if twoWay && destCount > srcCount {
+		if err := source.addPlays(ctx, src.RatingKey, destCount-srcCount); err != nil {
+			return ToSource, err
+		}
+		direction = ToSource
+	}
+
+	if srcRating.UserRating == destRating.UserRating {
+		return direction, nil
+	}
+	toSource := false
+	if twoWay && destRating.UserRating > 0 {
+		switch policy {
+		case DestinationWins:
+			toSource = true
+		case NewestWins:
+			toSource = destRating.LastRatedAt > srcRating.LastRatedAt
+		}
+	}
+	if toSource {
+		return ToSource, source.SetRating(ctx, src.RatingKey, destRating.UserRating)
+	}
+	if srcRating.UserRating > 0 {
+		return ToDestination, dest.SetRating(ctx, destItem.RatingKey, srcRating.UserRating)
+	}
+	return direction, nil
+}
+
+// addPlays scrobbles an item once for each play, since Plex has no way to set the play count directly
+func (p *Server) addPlays(ctx *context.Context, ratingKey string, plays int64) error {
+	for i := int64(0); i < plays; i++ {
+		if err := p.playStateRequest(ctx, "scrobble", ratingKey, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
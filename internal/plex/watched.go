@@ -0,0 +1,162 @@
+package plex
+
+import (
+	"context"
+	"fmt"
+	"github.com/jrudio/go-plex-client"
+	"io"
+	"net/url"
+)
+
+const libraryIdentifier = "com.plexapp.plugins.library"
+
+type ConflictPolicy string
+
+const (
+	NewestWins      ConflictPolicy = "newest-wins"
+	SourceWins      ConflictPolicy = "source-wins"
+	DestinationWins ConflictPolicy = "destination-wins"
+)
+
+// Direction is the way play state was copied between two matched items
+type Direction int
+
+const (
+	NoChange Direction = iota
+	ToDestination
+	ToSource
+)
+
+// WatchState is the play state of a single item
+type WatchState struct {
+	Watched      bool
+	ViewOffset   int
+	LastViewedAt int
+	UpdatedAt    int
+}
+
+func GetWatchState(item plex.Metadata) WatchState {
+	viewCount, _ := item.ViewCount.Int64()
+	return WatchState{
+		Watched:      viewCount > 0,
+		ViewOffset:   item.ViewOffset,
+		LastViewedAt: item.LastViewedAt,
+		UpdatedAt:    item.UpdatedAt,
+	}
+}
+
+// Equal compares the parts of the play state which are synced
+func (s WatchState) Equal(other WatchState) bool {
+	return s.Watched == other.Watched && s.ViewOffset == other.ViewOffset
+}
+
+// newerThan reports whether the item was played after other. UpdatedAt is when the metadata was last
+// refreshed rather than a play, so it is only compared when neither item was ever played.
+func (s WatchState) newerThan(other WatchState) bool {
+	if s.LastViewedAt > 0 || other.LastViewedAt > 0 {
+		return s.LastViewedAt > other.LastViewedAt
+	}
+	return s.UpdatedAt > other.UpdatedAt
+}
+
+// ParseConflictPolicy returns the named policy, defaulting to newest-wins
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	switch ConflictPolicy(name) {
+	case "", NewestWins:
+		return NewestWins, nil
+	case SourceWins, DestinationWins:
+		return ConflictPolicy(name), nil
+	}
+	return NewestWins, fmt.Errorf("unknown conflict policy: %s", name)
+}
+
+// ResolveWatched decides which side's play state wins for a pair of matched items
+func ResolveWatched(src WatchState, dest WatchState, policy ConflictPolicy) Direction {
+	if src.Equal(dest) {
+		return NoChange
+	}
+	switch policy {
+	case SourceWins:
+		return ToDestination
+	case DestinationWins:
+		return ToSource
+	}
+	if dest.newerThan(src) {
+		return ToSource
+	}
+	return ToDestination
+}
+
+// SyncWatched copies the watched flag and resume position of src onto dest, which must be an item on this
+// server. Nothing is ever unmarked.
+func (p *Server) SyncWatched(ctx *context.Context, src plex.Metadata, dest plex.Metadata) (bool, error) {
+	srcState := GetWatchState(src)
+	destState := GetWatchState(dest)
+	if !srcState.Watched && srcState.ViewOffset == 0 {
+		return false, nil
+	}
+	if destState.Watched {
+		srcState.Watched = true
+	}
+	return p.SetWatchState(ctx, dest.RatingKey, destState, srcState)
+}
+
+// SyncWatchedBoth reconciles the play state of two matched items, copying it in whichever direction the
+// policy decides
+func SyncWatchedBoth(ctx *context.Context, source *Server, src plex.Metadata, dest *Server, destItem plex.Metadata, policy ConflictPolicy) (Direction, error) {
+	srcState := GetWatchState(src)
+	destState := GetWatchState(destItem)
+	switch direction := ResolveWatched(srcState, destState, policy); direction {
+	case ToDestination:
+		_, err := dest.SetWatchState(ctx, destItem.RatingKey, destState, srcState)
+		return direction, err
+	case ToSource:
+		_, err := source.SetWatchState(ctx, src.RatingKey, srcState, destState)
+		return direction, err
+	}
+	return NoChange, nil
+}
+
+// SetWatchState updates an item on this server from its current play state to the wanted one. It returns
+// whether anything was changed.
+func (p *Server) SetWatchState(ctx *context.Context, ratingKey string, current WatchState, wanted WatchState) (bool, error) {
+	changed := false
+	if wanted.Watched && !current.Watched {
+		if err := p.playStateRequest(ctx, "scrobble", ratingKey, nil); err != nil {
+			return changed, err
+		}
+		changed = true
+	} else if !wanted.Watched && current.Watched {
+		if err := p.playStateRequest(ctx, "unscrobble", ratingKey, nil); err != nil {
+			return changed, err
+		}
+		changed = true
+	}
+	if wanted.ViewOffset != current.ViewOffset {
+		args := url.Values{}
+		args.Set("time", fmt.Sprint(wanted.ViewOffset))
+		args.Set("state", "stopped")
+		if err := p.playStateRequest(ctx, "progress", ratingKey, args); err != nil {
+			return changed, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+// playStateRequest calls one of the /:/scrobble, /:/unscrobble or /:/progress endpoints. Each scrobble adds a
+// play, so scrobbles are not retried once the server may have acted on them, and neither are unscrobbles.
+func (p *Server) playStateRequest(ctx *context.Context, action string, ratingKey string, args url.Values) error {
+	if args == nil {
+		args = url.Values{}
+	}
+	args.Set("key", ratingKey)
+	args.Set("identifier", libraryIdentifier)
+	query := fmt.Sprintf("%s/:/%s?%s", p.URL, action, args.Encode())
+	resp, err := p.send(ctx, "GET", query, nil, action == "progress")
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
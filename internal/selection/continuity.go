@@ -0,0 +1,65 @@
+package selection
+
+import (
+	"sort"
+)
+
+// DefaultEpisodesPerShow is how many episodes of each show next-unwatched keeps when the playlist doesn't say
+const DefaultEpisodesPerShow = 3
+
+// nextUnwatched keeps, for each show, the next unwatched episodes after the last one watched on the source
+// server, in season and episode order. The shows are then taken in turn, so a show which has used up its
+// episodes stops taking space from the others. Items which aren't episodes follow in playlist order.
+type nextUnwatched struct{}
+
+func (nextUnwatched) Order(items []Item, env *Env) []Item {
+	limit := DefaultEpisodesPerShow
+	if env.Playlist != nil && env.Playlist.EpisodesPerShow > 0 {
+		limit = env.Playlist.EpisodesPerShow
+	}
+
+	var episodes []Item
+	var others []Item
+	for _, item := range items {
+		if item.Value.Type == "episode" {
+			episodes = append(episodes, item)
+		} else {
+			others = append(others, item)
+		}
+	}
+
+	shows, byShow := groupByShow(episodes)
+	for _, show := range shows {
+		byShow[show] = nextEpisodes(byShow[show], limit)
+	}
+	return append(roundRobin(shows, byShow), others...)
+}
+
+// nextEpisodes returns up to limit unwatched episodes following the last watched episode of a show
+func nextEpisodes(episodes []Item, limit int) []Item {
+	sort.SliceStable(episodes, func(i, j int) bool {
+		a, b := episodes[i].Value, episodes[j].Value
+		if a.Season != b.Season {
+			return a.Season < b.Season
+		}
+		return a.Episode < b.Episode
+	})
+
+	position := 0
+	for i, episode := range episodes {
+		if episode.Value.ViewCount > 0 {
+			position = i + 1
+		}
+	}
+
+	var next []Item
+	for _, episode := range episodes[position:] {
+		if len(next) == limit {
+			break
+		}
+		if episode.Value.ViewCount == 0 {
+			next = append(next, episode)
+		}
+	}
+	return next
+}
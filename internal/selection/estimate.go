@@ -0,0 +1,70 @@
+package selection
+
+import (
+	"path"
+	"plex-go-sync/internal/models"
+	"strings"
+)
+
+// Actions the clone takes for an item, as predicted from what Plex reports about the source
+const (
+	ActionKeep      = "keep"      // already on the destination
+	ActionCopy      = "copy"      // copied as it is
+	ActionRemux     = "remux"     // streams copied into the configured container
+	ActionTranscode = "transcode" // encoded to the configured format
+	ActionDelete    = "delete"    // removed from the destination
+)
+
+// PredictAction guesses what the clone will do with an item, following the same rules as the probe of the
+// source file: video within the bitrate and height limits is copied or remuxed, anything else is encoded.
+func PredictAction(config *models.Config, item models.PlaylistItem) string {
+	ext := strings.TrimPrefix(strings.ToLower(path.Ext(item.Paths[0])), ".")
+	bitrate := item.Bitrate * 1000
+	if item.Type == "track" {
+		format := config.AudioFormat
+		if ext == format.Extension() && strings.EqualFold(item.Codec, format.Format) &&
+			bitrate > 0 && bitrate <= format.GetBitrate() {
+			return ActionCopy
+		}
+		return ActionTranscode
+	}
+	if bitrate <= 0 || bitrate > config.MediaFormat.BitrateFilter ||
+		item.Height <= 0 || item.Height > config.MediaFormat.HeightFilter {
+		return ActionTranscode
+	}
+	if ext == config.MediaFormat.Format && len(item.GetParts(item.Paths[0])) == 1 {
+		return ActionCopy
+	}
+	return ActionRemux
+}
+
+// EstimateSize guesses the size an item will take on the destination. Video over the configured bitrate is
+// encoded down to about that bitrate and tracks to the audio bitrate, anything else is copied as it is. When
+// Plex doesn't report the size of the source, the item is assumed to use the most the encode would, and 0 is
+// returned if even that isn't known.
+func EstimateSize(config *models.Config, item models.PlaylistItem) int64 {
+	seconds := item.Duration.Seconds()
+	bitrate := int64(item.Bitrate) * 1000
+	if bitrate == 0 && seconds > 0 {
+		bitrate = int64(float64(item.Size) * 8 / seconds)
+	}
+
+	target := int64(config.MediaFormat.BitrateFilter)
+	if item.Type == "track" {
+		target = int64(config.AudioFormat.GetBitrate())
+	}
+	if item.Size <= 0 {
+		rate := bitrate
+		if rate <= 0 || (target > 0 && rate > target) {
+			rate = target
+		}
+		if rate <= 0 || seconds <= 0 {
+			return 0
+		}
+		return int64(float64(rate) / 8 * seconds)
+	}
+	if target <= 0 || bitrate <= target || seconds <= 0 {
+		return item.Size
+	}
+	return int64(float64(target) / 8 * seconds)
+}
@@ -0,0 +1,55 @@
+package selection
+
+import (
+	"plex-go-sync/internal/models"
+	"sync"
+	"time"
+)
+
+const historyFile = "copy-history.json"
+
+// History remembers when each item was last copied to the destination, by item key
+type History struct {
+	mutex  sync.Mutex
+	path   string
+	Copied map[string]time.Time `json:"copied"`
+}
+
+// LoadHistory reads the copy history kept next to the config file, starting an empty one if there is none yet
+func LoadHistory(config *models.Config) *History {
+	history := &History{path: config.DataFile(historyFile), Copied: make(map[string]time.Time)}
+	loadFile(history.path, "copy history", history)
+	if history.Copied == nil {
+		history.Copied = make(map[string]time.Time)
+	}
+	return history
+}
+
+// LastCopied returns when an item with any of the keys was last copied, or the zero time if it never was
+func (h *History) LastCopied(keys []string) time.Time {
+	var last time.Time
+	if h == nil {
+		return last
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for _, key := range keys {
+		if copied := h.Copied[key]; copied.After(last) {
+			last = copied
+		}
+	}
+	return last
+}
+
+// Record notes that an item was copied and saves the history
+func (h *History) Record(keys []string, copied time.Time) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for _, key := range keys {
+		h.Copied[key] = copied
+	}
+	saveFile(h.path, "copy history", h)
+}
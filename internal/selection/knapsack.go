@@ -0,0 +1,149 @@
+package selection
+
+import (
+	"fmt"
+	"plex-go-sync/internal/logger"
+	"plex-go-sync/internal/models"
+	. "plex-go-sync/internal/structures"
+)
+
+// Pack modes, as used in the pack setting of a playlist
+const (
+	PackPriority = "priority" // earlier items in the order are worth more
+	PackHours    = "hours"    // the most hours of content
+	PackItems    = "items"    // the most items
+)
+
+// packResolution is the number of steps the budget is split into. Sizes are rounded up to a step, so the
+// chosen items always fit, at the cost of leaving up to a step per item unused.
+const packResolution = 4096
+
+// Candidate is an item which could be copied, with the size it is expected to take on the destination
+type Candidate struct {
+	Item
+	Size  int64
+	Fixed bool // already on the destination, so it is always kept
+}
+
+// Pack chooses the candidates which fit in budget with the most value, as a 0/1 knapsack, and returns them in
+// their original order. Candidates of unknown size are left out, since they can't be packed safely.
+func Pack(candidates []Candidate, budget int64, mode string) ([]Item, error) {
+	value, err := packValue(mode, len(candidates))
+	if err != nil {
+		return nil, err
+	}
+
+	var optional []int
+	for i, candidate := range candidates {
+		if candidate.Fixed {
+			budget -= candidate.Size
+		} else if candidate.Size <= 0 {
+			logger.LogVerbose("Leaving out", candidate.Keys, "- its size is unknown")
+		} else {
+			optional = append(optional, i)
+		}
+	}
+	if budget < 0 {
+		budget = 0
+	}
+
+	steps := int64(packResolution)
+	if budget < steps {
+		steps = budget
+	}
+	unit := int64(1)
+	if steps > 0 {
+		unit = (budget + steps - 1) / steps
+	}
+
+	// best[w] is the most value using w steps, and taken[i][w] records whether optional item i is part of it
+	best := make([]float64, steps+1)
+	taken := make([][]bool, len(optional))
+	for i, index := range optional {
+		taken[i] = make([]bool, steps+1)
+		weight := (candidates[index].Size + unit - 1) / unit
+		if weight > steps {
+			continue
+		}
+		v := value(index, candidates[index].Item)
+		for w := steps; w >= weight; w-- {
+			if best[w-weight]+v > best[w] {
+				best[w] = best[w-weight] + v
+				taken[i][w] = true
+			}
+		}
+	}
+
+	chosen := make([]bool, len(candidates))
+	for i, candidate := range candidates {
+		chosen[i] = candidate.Fixed
+	}
+	for i, w := len(optional)-1, steps; i >= 0; i-- {
+		if taken[i][w] {
+			index := optional[i]
+			chosen[index] = true
+			w -= (candidates[index].Size + unit - 1) / unit
+		}
+	}
+
+	items := make([]Item, 0, len(candidates))
+	for i, candidate := range candidates {
+		if chosen[i] {
+			items = append(items, candidate.Item)
+		}
+	}
+	return items, nil
+}
+
+// PackPlaylist replaces the items of the playlist with the ones chosen to fill budget, keeping their order.
+// Items already on the destination are always kept, and items which would go over a quota, in order, are left
+// out before packing.
+func PackPlaylist(config *models.Config, sizes *SizeHistory, playlist *models.Playlist, existing map[string]uint64,
+	budget int64) error {
+	usage, err := NewUsage(playlist.Quotas)
+	if err != nil {
+		return err
+	}
+	usage.Trim(&playlist.Items, existing)
+
+	candidates := make([]Candidate, 0, playlist.Items.Len())
+	for item := playlist.Items.Front(); item != nil; item = item.Next() {
+		candidate := Candidate{
+			Item: Item{Keys: item.Keys, Value: item.Value},
+			Size: sizes.Estimate(config, item.Value),
+		}
+		if size, exists := existingSize(item.Keys, existing); exists {
+			candidate.Size = size
+			candidate.Fixed = true
+		} else if fits, _ := usage.Fits(item.Value, candidate.Size); fits {
+			usage.Add(item.Value, candidate.Size)
+		} else {
+			continue
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	chosen, err := Pack(candidates, budget, playlist.Pack)
+	if err != nil {
+		return err
+	}
+	items := NewOrderedMap[models.PlaylistItem]()
+	for _, item := range chosen {
+		items.SetAll(item.Keys, item.Value)
+	}
+	logger.LogInfo("Chose", len(chosen), "of", len(candidates), "items of", playlist.Name, "by", playlist.Pack)
+	playlist.Items = items
+	return nil
+}
+
+func packValue(mode string, count int) (func(index int, item Item) float64, error) {
+	switch mode {
+	case PackPriority:
+		return func(index int, _ Item) float64 { return float64(count - index) }, nil
+	case PackHours:
+		return func(_ int, item Item) float64 { return item.Value.Duration.Hours() }, nil
+	case PackItems:
+		return func(int, Item) float64 { return 1 }, nil
+	}
+	return nil, fmt.Errorf("unknown pack mode %s, expected one of %s, %s, %s", mode, PackPriority, PackHours, PackItems)
+}
@@ -0,0 +1,146 @@
+package selection
+
+import (
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"plex-go-sync/internal/models"
+	. "plex-go-sync/internal/structures"
+)
+
+// Quota groups, as used in the by setting of a quota
+const (
+	QuotaShow          = "show"
+	QuotaGenre         = "genre"
+	QuotaContentRating = "contentRating"
+)
+
+// Usage counts the items and bytes each show, genre or content rating takes up in a playlist, to check them
+// against the quotas of the playlist. A nil Usage has no quotas, so everything fits.
+type Usage struct {
+	quotas []models.Quota
+	items  []map[string]int
+	sizes  []map[string]int64
+}
+
+// NewUsage starts counting for the quotas of a playlist, returning nil if it has none. Quotas with an unknown
+// group or a size which can't be parsed are an error, rather than no limit.
+func NewUsage(quotas []models.Quota) (*Usage, error) {
+	if len(quotas) == 0 {
+		return nil, nil
+	}
+	usage := &Usage{quotas: quotas}
+	for _, quota := range quotas {
+		switch quota.By {
+		case QuotaShow, QuotaGenre, QuotaContentRating:
+		default:
+			return nil, fmt.Errorf("unknown quota %s, expected one of %s, %s, %s", quota.By, QuotaShow, QuotaGenre,
+				QuotaContentRating)
+		}
+		if quota.RawSize != "" {
+			if _, err := humanize.ParseBytes(quota.RawSize); err != nil {
+				return nil, fmt.Errorf("invalid size %s for the %s quota: %w", quota.RawSize, quota.By, err)
+			}
+		}
+		usage.items = append(usage.items, make(map[string]int))
+		usage.sizes = append(usage.sizes, make(map[string]int64))
+	}
+	return usage, nil
+}
+
+// NeedsGenres reports whether any quota is by genre, which takes an extra request per show or movie
+func NeedsGenres(quotas []models.Quota) bool {
+	for _, quota := range quotas {
+		if quota.By == QuotaGenre {
+			return true
+		}
+	}
+	return false
+}
+
+// groups returns the shows, genres or content ratings of an item a quota counts it towards. Items without
+// one, such as a movie for a show quota, are not limited by the quota.
+func groups(quota models.Quota, item models.PlaylistItem) []string {
+	var group string
+	switch quota.By {
+	case QuotaGenre:
+		return item.Genres
+	case QuotaShow:
+		group = item.Parent
+	case QuotaContentRating:
+		group = item.ContentRating
+	}
+	if group == "" {
+		return nil
+	}
+	return []string{group}
+}
+
+// Fits reports whether an item of size can be added without going over a quota. If it can't, the quota and
+// group which are full are returned as well.
+func (u *Usage) Fits(item models.PlaylistItem, size int64) (bool, string) {
+	if u == nil {
+		return true, ""
+	}
+	for i, quota := range u.quotas {
+		maxSize := quota.GetSize()
+		for _, group := range groups(quota, item) {
+			if quota.Items > 0 && u.items[i][group]+1 > quota.Items {
+				return false, fmt.Sprintf("%s %s has %d items", quota.By, group, u.items[i][group])
+			}
+			if maxSize > 0 && u.sizes[i][group]+size > maxSize {
+				return false, fmt.Sprintf("%s %s has %s", quota.By, group, humanize.Bytes(uint64(u.sizes[i][group])))
+			}
+		}
+	}
+	return true, ""
+}
+
+// Add counts an item which is on the destination
+func (u *Usage) Add(item models.PlaylistItem, size int64) {
+	u.change(item, 1, size)
+}
+
+// Remove stops counting an item which was removed from the destination
+func (u *Usage) Remove(item models.PlaylistItem, size int64) {
+	u.change(item, -1, -size)
+}
+
+func (u *Usage) change(item models.PlaylistItem, items int, size int64) {
+	if u == nil {
+		return
+	}
+	for i, quota := range u.quotas {
+		for _, group := range groups(quota, item) {
+			u.items[i][group] += items
+			u.sizes[i][group] += size
+		}
+	}
+}
+
+// Trim counts the items of a playlist which are on the destination, in order, and returns the ones which go
+// over a quota. Those are not counted, so the caller is expected to remove them.
+func (u *Usage) Trim(items *OrderedMap[models.PlaylistItem], existing map[string]uint64) []Item {
+	var over []Item
+	for item := items.Front(); item != nil; item = item.Next() {
+		size, ok := existingSize(item.Keys, existing)
+		if !ok {
+			continue
+		}
+		if fits, _ := u.Fits(item.Value, size); fits {
+			u.Add(item.Value, size)
+		} else {
+			over = append(over, Item{Keys: item.Keys, Value: item.Value})
+		}
+	}
+	return over
+}
+
+// existingSize returns the size of the copy of an item on the destination, if there is one
+func existingSize(keys []string, existing map[string]uint64) (int64, bool) {
+	for _, key := range keys {
+		if size, ok := existing[key]; ok {
+			return int64(size), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,142 @@
+package selection
+
+import (
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"math"
+	"plex-go-sync/internal/models"
+	"sync"
+)
+
+const sizeHistoryFile = "size-history.json"
+
+// smoothing is how far each finished copy moves the ratio of its profile towards its own ratio
+const smoothing = 0.25
+
+// SizeHistory learns, for each encoding profile, how the actual size of a copy compares with EstimateSize. It
+// also keeps how accurate the estimates of this run were.
+type SizeHistory struct {
+	mutex  sync.Mutex
+	path   string
+	Ratios map[string]Ratio `json:"ratios"`
+	run    accuracy
+}
+
+// Ratio is the running ratio of actual to estimated size of a profile
+type Ratio struct {
+	Ratio  float64 `json:"ratio"`
+	Copies int     `json:"copies"`
+}
+
+type accuracy struct {
+	copies      int
+	estimated   int64
+	actual      int64
+	error       float64 // sum of the absolute relative errors
+	skipped     int
+	skippedSize int64
+}
+
+// LoadSizeHistory reads the size history kept next to the config file, starting an empty one if there is none
+// yet
+func LoadSizeHistory(config *models.Config) *SizeHistory {
+	history := &SizeHistory{path: config.DataFile(sizeHistoryFile), Ratios: make(map[string]Ratio)}
+	loadFile(history.path, "size history", history)
+	if history.Ratios == nil {
+		history.Ratios = make(map[string]Ratio)
+	}
+	return history
+}
+
+// Profile names the way an item is written to the destination. Each profile compresses differently, so each
+// has its own ratio.
+func Profile(config *models.Config, item models.PlaylistItem) string {
+	action := PredictAction(config, item)
+	switch {
+	case action == ActionCopy:
+		return action
+	case item.Type == "track":
+		return fmt.Sprintf("%s %s %s", action, config.AudioFormat.Format, config.AudioFormat.Bitrate)
+	case action == ActionTranscode:
+		return fmt.Sprintf("%s %s %dp crf %d", action, config.MediaFormat.Format, config.MediaFormat.HeightFilter,
+			config.MediaFormat.CrfFilter)
+	}
+	return action + " " + config.MediaFormat.Format
+}
+
+// Estimate returns EstimateSize corrected by the ratio learned for the profile of the item
+func (h *SizeHistory) Estimate(config *models.Config, item models.PlaylistItem) int64 {
+	estimate := EstimateSize(config, item)
+	if h == nil {
+		return estimate
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.correct(Profile(config, item), estimate)
+}
+
+func (h *SizeHistory) correct(profile string, estimate int64) int64 {
+	if ratio, ok := h.Ratios[profile]; ok && ratio.Copies > 0 {
+		return int64(float64(estimate) * ratio.Ratio)
+	}
+	return estimate
+}
+
+// Record adds the actual size of a finished copy to the ratio of its profile and saves the history
+func (h *SizeHistory) Record(config *models.Config, item models.PlaylistItem, actual int64) {
+	raw := EstimateSize(config, item)
+	if h == nil || raw <= 0 || actual <= 0 {
+		return
+	}
+	profile := Profile(config, item)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	estimate := h.correct(profile, raw)
+	h.run.copies++
+	h.run.estimated += estimate
+	h.run.actual += actual
+	h.run.error += math.Abs(float64(actual-estimate)) / float64(actual)
+
+	ratio := h.Ratios[profile]
+	if ratio.Copies == 0 {
+		ratio.Ratio = float64(actual) / float64(raw)
+	} else {
+		ratio.Ratio += smoothing * (float64(actual)/float64(raw) - ratio.Ratio)
+	}
+	ratio.Copies++
+	h.Ratios[profile] = ratio
+	saveFile(h.path, "size history", h)
+}
+
+// Skip notes an item which was not copied because its estimate didn't fit
+func (h *SizeHistory) Skip(estimate int64) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.run.skipped++
+	h.run.skippedSize += estimate
+}
+
+// Summary describes the accuracy of the estimates of this run
+func (h *SizeHistory) Summary() string {
+	if h == nil {
+		return ""
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	run := h.run
+	summary := "Size estimates: no items copied"
+	if run.copies > 0 {
+		summary = fmt.Sprintf("Size estimates: %d items copied, estimated %s, actual %s, average error %.0f%%",
+			run.copies, humanize.Bytes(uint64(run.estimated)), humanize.Bytes(uint64(run.actual)),
+			100*run.error/float64(run.copies))
+	}
+	if run.skipped > 0 {
+		summary += fmt.Sprintf(", %d items skipped before encoding (%s)", run.skipped,
+			humanize.Bytes(uint64(run.skippedSize)))
+	}
+	return summary
+}
@@ -0,0 +1,38 @@
+package selection
+
+import (
+	"encoding/json"
+	"os"
+	"plex-go-sync/internal/logger"
+	"plex-go-sync/internal/models"
+)
+
+// loadFile decodes a json file into v, leaving v as it is if the file doesn't exist yet
+func loadFile(path string, name string, v any) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+	if err := json.NewDecoder(file).Decode(v); err != nil {
+		logger.LogWarning("Error reading ", name, ": ", err)
+	}
+}
+
+// saveFile writes v to a temporary file next to path and renames it over path, so an interrupted run never
+// leaves a truncated file behind
+func saveFile(path string, name string, v any) {
+	if err := writeFile(path, v); err != nil {
+		logger.LogWarning("Error writing ", name, ": ", err)
+	}
+}
+
+func writeFile(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return models.WriteFileAtomic(path, append(data, '\n'), 0644)
+}
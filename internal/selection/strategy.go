@@ -0,0 +1,169 @@
+package selection
+
+import (
+	"fmt"
+	"math/rand"
+	"plex-go-sync/internal/models"
+	. "plex-go-sync/internal/structures"
+	"sort"
+	"strings"
+)
+
+// Orders of a playlist, as used in its order setting
+const (
+	OrderRandom              = "random"
+	OrderPlaylist            = "playlist"
+	OrderUnwatchedFirst      = "unwatched-first"
+	OrderRecentlyAdded       = "recently-added"
+	OrderHighestRated        = "highest-rated"
+	OrderOldestNotSeen       = "oldest-not-seen"
+	OrderLeastRecentlyCopied = "least-recently-copied"
+	OrderNextUnwatched       = "next-unwatched"
+)
+
+// Item is a playlist item with the keys it is stored under
+type Item struct {
+	Keys  []string
+	Value models.PlaylistItem
+}
+
+// Env holds what a strategy may use besides the items. Everything random has to come from Random, so that
+// the same seed gives the same order.
+type Env struct {
+	Playlist *models.Playlist
+	Random   *rand.Rand
+	History  *History
+}
+
+// Strategy decides which items of a playlist are copied first. Order returns the items highest priority
+// first, leaving out any which should not be copied at all.
+type Strategy interface {
+	Order(items []Item, env *Env) []Item
+}
+
+var strategies = map[string]Strategy{
+	OrderRandom:              randomOrder{},
+	OrderPlaylist:            playlistOrder{},
+	OrderUnwatchedFirst:      byKey(func(item models.PlaylistItem) float64 { return boolKey(item.ViewCount > 0) }),
+	OrderRecentlyAdded:       byKey(func(item models.PlaylistItem) float64 { return -float64(item.AddedAt) }),
+	OrderHighestRated:        byKey(func(item models.PlaylistItem) float64 { return -item.Rating }),
+	OrderOldestNotSeen:       byKey(func(item models.PlaylistItem) float64 { return float64(item.LastViewedAt) }),
+	OrderLeastRecentlyCopied: leastRecentlyCopied{},
+	OrderNextUnwatched:       nextUnwatched{},
+}
+
+// Register adds a strategy, or replaces the strategy with the same name
+func Register(name string, strategy Strategy) {
+	strategies[name] = strategy
+}
+
+// Get returns the strategy for an order, which defaults to random
+func Get(order string) (Strategy, error) {
+	if order == "" {
+		order = OrderRandom
+	}
+	strategy, ok := strategies[order]
+	if !ok {
+		names := make([]string, 0, len(strategies))
+		for name := range strategies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown order %s, expected one of %s", order, strings.Join(names, ", "))
+	}
+	return strategy, nil
+}
+
+// Apply orders the items of a map with a strategy and returns them in a new map
+func Apply(items *OrderedMap[models.PlaylistItem], strategy Strategy, env *Env) OrderedMap[models.PlaylistItem] {
+	list := make([]Item, 0, items.Len())
+	for item := items.Front(); item != nil; item = item.Next() {
+		list = append(list, Item{Keys: item.Keys, Value: item.Value})
+	}
+	list = strategy.Order(list, env)
+
+	ordered := NewOrderedMap[models.PlaylistItem]()
+	for _, item := range list {
+		ordered.SetAll(item.Keys, item.Value)
+	}
+	return ordered
+}
+
+// playlistOrder keeps the order of the Plex playlist
+type playlistOrder struct{}
+
+func (playlistOrder) Order(items []Item, _ *Env) []Item {
+	return items
+}
+
+// randomOrder shuffles the items. For tv show playlists, the shuffled episodes are then picked from each show
+// in turn, so every show gets some episodes copied.
+type randomOrder struct{}
+
+func (randomOrder) Order(items []Item, env *Env) []Item {
+	env.Random.Shuffle(len(items), func(i, j int) {
+		items[i], items[j] = items[j], items[i]
+	})
+	if len(items) == 0 || items[0].Value.Type != "episode" {
+		return items
+	}
+	return roundRobin(groupByShow(items))
+}
+
+// groupByShow splits items by their show, keeping the order of the items within each show. The shows are
+// returned in the order they first appear.
+func groupByShow(items []Item) ([]string, map[string][]Item) {
+	var shows []string
+	episodes := make(map[string][]Item)
+	for _, item := range items {
+		if _, ok := episodes[item.Value.Parent]; !ok {
+			shows = append(shows, item.Value.Parent)
+		}
+		episodes[item.Value.Parent] = append(episodes[item.Value.Parent], item)
+	}
+	return shows, episodes
+}
+
+// roundRobin takes the first item of each show in turn, then the second, and so on. A show which runs out of
+// items is skipped, so the remaining shows share what is left.
+func roundRobin(shows []string, episodes map[string][]Item) []Item {
+	var items []Item
+	for added := true; added; {
+		added = false
+		for _, show := range shows {
+			if len(episodes[show]) > 0 {
+				items = append(items, episodes[show][0])
+				episodes[show] = episodes[show][1:]
+				added = true
+			}
+		}
+	}
+	return items
+}
+
+// byKey sorts by a value of each item, lowest first. Ties keep the playlist order.
+type byKey func(item models.PlaylistItem) float64
+
+func (key byKey) Order(items []Item, _ *Env) []Item {
+	sort.SliceStable(items, func(i, j int) bool {
+		return key(items[i].Value) < key(items[j].Value)
+	})
+	return items
+}
+
+func boolKey(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// leastRecentlyCopied puts the items which were never copied first, then the ones copied longest ago
+type leastRecentlyCopied struct{}
+
+func (leastRecentlyCopied) Order(items []Item, env *Env) []Item {
+	sort.SliceStable(items, func(i, j int) bool {
+		return env.History.LastCopied(items[i].Keys).Before(env.History.LastCopied(items[j].Keys))
+	})
+	return items
+}
@@ -6,11 +6,18 @@ import (
 	"github.com/urfave/cli/v2"
 	"os"
 	"os/signal"
+	"plex-go-sync/internal/actions/auth"
 	"plex-go-sync/internal/actions/clean"
 	"plex-go-sync/internal/actions/clone"
+	"plex-go-sync/internal/actions/discover"
+	"plex-go-sync/internal/actions/paths"
+	"plex-go-sync/internal/actions/plan"
+	"plex-go-sync/internal/actions/serve"
 	"plex-go-sync/internal/actions/sync"
 	"plex-go-sync/internal/logger"
+	"plex-go-sync/internal/plex"
 	"syscall"
+	"time"
 )
 
 func main() {
@@ -61,6 +68,18 @@ func main() {
 						Aliases: []string{"o"},
 						Usage:   "Destination server address",
 					},
+					&cli.BoolFlag{
+						Name:  "two-way",
+						Usage: "Sync play status in both directions",
+					},
+					&cli.StringFlag{
+						Name:  "conflict-policy",
+						Usage: "When syncing both ways, one of newest-wins, source-wins, destination-wins",
+					},
+					&cli.IntFlag{
+						Name:  "page-size",
+						Usage: "Number of items to fetch per request from Plex",
+					},
 					&cli.StringFlag{
 						Name:  "loglevel",
 						Usage: "One of VERBOSE, INFO, WARN, ERROR",
@@ -131,6 +150,22 @@ func main() {
 						Usage:   "Number of threads to use",
 						Value:   2,
 					},
+					&cli.BoolFlag{
+						Name:  "recreate-playlists",
+						Usage: "Recreate the cloned playlists on the destination server",
+					},
+					&cli.BoolFlag{
+						Name:  "rotate",
+						Usage: "Remove watched items from the destination, after syncing their play state, before copying new ones",
+					},
+					&cli.IntFlag{
+						Name:  "rotate-after",
+						Usage: "Only rotate out items watched at least this many days ago",
+					},
+					&cli.IntFlag{
+						Name:  "page-size",
+						Usage: "Number of items to fetch per request from Plex",
+					},
 					&cli.StringFlag{
 						Name:  "loglevel",
 						Usage: "One of VERBOSE, INFO, WARN, ERROR",
@@ -185,6 +220,196 @@ func main() {
 						Aliases: []string{"p"},
 						Usage:   "Playlists to use",
 					},
+					&cli.IntFlag{
+						Name:  "page-size",
+						Usage: "Number of items to fetch per request from Plex",
+					},
+					&cli.StringFlag{
+						Name:  "loglevel",
+						Usage: "One of VERBOSE, INFO, WARN, ERROR",
+					},
+				},
+			},
+			{
+				Name: "plan",
+				Usage: "Show what clone would keep, copy, remux, transcode and delete for each playlist, with " +
+					"estimated sizes, without changing anything",
+				Action: plan.FromContext,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:      "config",
+						Aliases:   []string{"c"},
+						Value:     "configs.json",
+						Usage:     "Load configuration from `FILE`",
+						TakesFile: true,
+					},
+					&cli.StringFlag{
+						Name:    "server",
+						Aliases: []string{"i"},
+						Usage:   "Plex server address",
+					},
+					&cli.StringFlag{
+						Name:    "token",
+						Aliases: []string{"t"},
+						Usage:   "Plex server token",
+					},
+					&cli.StringSliceFlag{
+						Name:    "playlist",
+						Aliases: []string{"p"},
+						Usage:   "Playlists to plan",
+					},
+					&cli.StringSliceFlag{
+						Name:  "size",
+						Usage: "Max size of playlist to copy",
+					},
+					&cli.StringFlag{
+						Name:    "destination-server",
+						Aliases: []string{"o"},
+						Usage:   "Destination server address",
+					},
+					&cli.StringFlag{
+						Name:    "destination",
+						Aliases: []string{"d", "dest"},
+						Usage:   "Destination path",
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Print the plan as JSON",
+					},
+					&cli.IntFlag{
+						Name:  "page-size",
+						Usage: "Number of items to fetch per request from Plex",
+					},
+					&cli.StringFlag{
+						Name:  "loglevel",
+						Usage: "One of VERBOSE, INFO, WARN, ERROR",
+					},
+				},
+			},
+			{
+				Name:   "discover",
+				Usage:  "List Plex servers on the local network",
+				Action: discover.FromContext,
+				Flags: []cli.Flag{
+					&cli.DurationFlag{
+						Name:  "timeout",
+						Usage: "How long to wait for servers to answer",
+						Value: plex.DefaultDiscoveryTimeout,
+					},
+					&cli.StringFlag{
+						Name:  "loglevel",
+						Usage: "One of VERBOSE, INFO, WARN, ERROR",
+					},
+				},
+			},
+			{
+				Name:  "paths",
+				Usage: "Check the path rules which map the paths reported by Plex to files",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "test",
+						Usage:     "Show the source file, destination file and destination server path for a source server path",
+						ArgsUsage: "PATH...",
+						Action:    paths.TestFromContext,
+						Flags: []cli.Flag{
+							&cli.PathFlag{
+								Name:      "config",
+								Aliases:   []string{"c"},
+								Value:     "configs.json",
+								Usage:     "Load configuration from `FILE`",
+								TakesFile: true,
+							},
+							&cli.StringFlag{
+								Name:  "type",
+								Usage: "Media type of the path, track for music",
+								Value: "movie",
+							},
+							&cli.StringFlag{
+								Name:  "loglevel",
+								Usage: "One of VERBOSE, INFO, WARN, ERROR",
+							},
+						},
+					},
+				},
+			},
+			{
+				Name:   "serve",
+				Usage:  "Receive Plex webhooks and sync the play status of each played item to the other server",
+				Action: serve.FromContext,
+				Flags: []cli.Flag{
+					&cli.PathFlag{
+						Name:      "config",
+						Aliases:   []string{"c"},
+						Value:     "configs.json",
+						Usage:     "Load configuration from `FILE`",
+						TakesFile: true,
+					},
+					&cli.StringFlag{
+						Name:    "server",
+						Aliases: []string{"i"},
+						Usage:   "Plex server address",
+					},
+					&cli.StringFlag{
+						Name:    "token",
+						Aliases: []string{"t"},
+						Usage:   "Plex server token",
+					},
+					&cli.StringFlag{
+						Name:    "destination-server",
+						Aliases: []string{"o"},
+						Usage:   "Destination server address",
+					},
+					&cli.StringFlag{
+						Name:  "listen",
+						Usage: "Address to listen on, the webhook url is http://ADDRESS/webhook",
+						Value: ":8090",
+					},
+					&cli.DurationFlag{
+						Name:  "retry-interval",
+						Usage: "How often to retry items which could not be synced",
+						Value: time.Minute,
+					},
+					&cli.DurationFlag{
+						Name:  "max-age",
+						Usage: "How long to keep retrying an item before giving up, 0 to keep it until it syncs",
+					},
+					&cli.StringFlag{
+						Name:  "loglevel",
+						Usage: "One of VERBOSE, INFO, WARN, ERROR",
+					},
+				},
+			},
+			{
+				Name:   "auth",
+				Usage:  "Link this app to a Plex account with a PIN and store the token",
+				Action: auth.FromContext,
+				Flags: []cli.Flag{
+					&cli.PathFlag{
+						Name:      "config",
+						Aliases:   []string{"c"},
+						Value:     "configs.json",
+						Usage:     "Write the token to the configuration `FILE`",
+						TakesFile: true,
+					},
+					&cli.PathFlag{
+						Name:      "credentials",
+						Usage:     "Write the token to a separate credentials `FILE` instead of the configuration",
+						TakesFile: true,
+					},
+					&cli.StringFlag{
+						Name:  "connection",
+						Usage: "Store the token for one server only, either source or destination",
+					},
+					&cli.StringFlag{
+						Name:  "plex-tv-url",
+						Usage: "Base address of the plex.tv API",
+						Value: plex.DefaultPlexTvURL,
+					},
+					&cli.StringFlag{
+						Name:  "link-url",
+						Usage: "Address where the PIN is entered",
+						Value: plex.DefaultLinkURL,
+					},
 					&cli.StringFlag{
 						Name:  "loglevel",
 						Usage: "One of VERBOSE, INFO, WARN, ERROR",
@@ -0,0 +1,103 @@
+package test
+
+import (
+	"github.com/dustin/go-humanize"
+	"plex-go-sync/internal/models"
+	"plex-go-sync/internal/structures"
+	"testing"
+)
+
+func TestAllocator(t *testing.T) {
+	items := structures.NewOrderedMap[models.PlaylistItem]()
+	items.Set("a", models.PlaylistItem{Paths: []string{"/tv/Show/Season 01/Show - s01e01.mkv"}})
+	playlists := []models.Playlist{
+		{Name: "Light", Weight: 1, Items: items},
+		{Name: "Heavy", Weight: 3, Items: items},
+		{Name: "Percent", RawSize: "10%", Items: items},
+		{Name: "Fixed", RawSize: "1G", Items: items},
+	}
+	allocator := models.NewAllocator(NewTestFileSystem("/tmp"), playlists)
+	budget := func(p *models.Playlist, existing int64) int64 {
+		size, err := allocator.Budget(p, existing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return size
+	}
+
+	// the playlists with a size are reserved first, even though they run last: 10% of 500GB and 1GB out of
+	// 100GB free, less the padding, leaves the rest to share 1:3
+	available := int64(humanize.GByte*100-500*humanize.MiByte) - humanize.GByte*51
+	if light := budget(&playlists[0], 0); light != available/4 {
+		t.Errorf("light share was %s, want %s", humanize.Bytes(uint64(light)), humanize.Bytes(uint64(available/4)))
+	}
+	heavy := budget(&playlists[1], humanize.GByte)
+	if want := humanize.GByte + available - available/4; heavy != want {
+		t.Errorf("heavy share was %s, want %s", humanize.Bytes(uint64(heavy)), humanize.Bytes(uint64(want)))
+	}
+	if size := budget(&playlists[2], 0); size != humanize.GByte*50 {
+		t.Errorf("10%% of 500GB was %s", humanize.Bytes(uint64(size)))
+	}
+	if size := budget(&playlists[3], 0); size != humanize.GByte {
+		t.Errorf("fixed size was %s", humanize.Bytes(uint64(size)))
+	}
+
+	// once a playlist with a size finds its existing files, it only keeps what it may still copy
+	allocator.Release(&playlists[0])
+	allocator.Release(&playlists[1])
+	budget(&playlists[2], humanize.GByte*30)
+	remaining, _ := allocator.Check(&playlists[3], humanize.GByte*100, humanize.GByte*100)
+	if want := int64(humanize.GByte*80 - 500*humanize.MiByte); remaining != want {
+		t.Errorf("remaining was %s, want %s", humanize.Bytes(uint64(remaining)), humanize.Bytes(uint64(want)))
+	}
+
+	// the remaining size never goes below nothing, even when another playlist reserved all the free space
+	full := []models.Playlist{{Name: "Full", RawSize: "100G", Items: items}, playlists[3]}
+	allocator = models.NewAllocator(NewTestFileSystem("/tmp"), full)
+	remaining, total := allocator.Check(&full[1], humanize.GByte, humanize.GByte*2)
+	if remaining != 0 || total != humanize.GByte {
+		t.Errorf("remaining was %s of %s, want 0 of 1GB", humanize.Bytes(uint64(remaining)), humanize.Bytes(uint64(total)))
+	}
+}
+
+func TestAllocatorVolumes(t *testing.T) {
+	tv := structures.NewOrderedMap[models.PlaylistItem]()
+	tv.Set("a", models.PlaylistItem{Paths: []string{"/tv/Show/Season 01/Show - s01e01.mkv"}})
+	movies := structures.NewOrderedMap[models.PlaylistItem]()
+	movies.Set("b", models.PlaylistItem{Paths: []string{"/movies/Film (2020)/Film.mkv"}})
+	playlists := []models.Playlist{
+		{Name: "Shows", Items: tv},
+		{Name: "Films", RawSize: "50G", Items: movies},
+	}
+	fs := &TestFileSystem{Path: "/tmp", Volumes: map[string]string{"tv": "sda", "movies": "sdb"}}
+	allocator := models.NewAllocator(fs, playlists)
+
+	// the films are on another volume, so all the free space of this one is left for the shows
+	size, err := allocator.Budget(&playlists[0], 0)
+	if want := int64(humanize.GByte*100 - 500*humanize.MiByte); err != nil || size != want {
+		t.Errorf("shows got %s %v, want %s", humanize.Bytes(uint64(size)), err, humanize.Bytes(uint64(want)))
+	}
+}
+
+func TestAllocatorInvalidSize(t *testing.T) {
+	items := structures.NewOrderedMap[models.PlaylistItem]()
+	items.Set("a", models.PlaylistItem{Paths: []string{"/tv/Show/Season 01/Show - s01e01.mkv"}})
+	for _, size := range []string{"lots", "12 parsecs", "half%", "0%", "-10%", "150%"} {
+		playlists := []models.Playlist{
+			{Name: "Invalid", RawSize: size, Items: items},
+			{Name: "Shared", Items: items},
+		}
+		allocator := models.NewAllocator(NewTestFileSystem("/tmp"), playlists)
+
+		// an invalid size fails instead of giving a budget of nothing, which would remove every copy
+		if budget, err := allocator.Budget(&playlists[0], humanize.GByte); err == nil {
+			t.Errorf("size %s: got budget %s, want an error", size, humanize.Bytes(uint64(budget)))
+		}
+		// and it doesn't reserve anything from the other playlists
+		budget, err := allocator.Budget(&playlists[1], 0)
+		if want := int64(humanize.GByte*100 - 500*humanize.MiByte); err != nil || budget != want {
+			t.Errorf("size %s: shared got %s %v, want %s", size, humanize.Bytes(uint64(budget)), err,
+				humanize.Bytes(uint64(want)))
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package test
+
+import (
+	"context"
+	"github.com/dustin/go-humanize"
+	client "github.com/jrudio/go-plex-client"
+	"plex-go-sync/internal/filesystem"
+	"plex-go-sync/internal/models"
+	"plex-go-sync/internal/plex"
+	"plex-go-sync/internal/structures"
+	"reflect"
+	"testing"
+)
+
+func TestPlaylistBases(t *testing.T) {
+	items := structures.NewOrderedMap[models.PlaylistItem]()
+	items.Set("a", models.PlaylistItem{Paths: []string{"/movies/Heat (1995)/Heat (1995).mkv"}})
+	items.Set("b", models.PlaylistItem{Paths: []string{"/movies-4k/Dune (2021)/Dune (2021).mkv", "/movies/Dune (2021)/Dune (2021).mkv"}})
+	items.Set("c", models.PlaylistItem{Paths: []string{"/movies-4k/Alien (1979)/Alien (1979).mkv"}})
+	playlist := models.Playlist{Name: "Movies", Items: items}
+
+	if bases := playlist.GetBases(); !reflect.DeepEqual(bases, []string{"movies", "movies-4k"}) {
+		t.Errorf("got bases %v", bases)
+	}
+
+	// both bases are on one volume, so its free space is only counted once
+	free, err := filesystem.GetTotalFreeSpace(NewTestFileSystem("/tmp"), playlist.GetBases())
+	if err != nil || free != humanize.GByte*100 {
+		t.Errorf("got free space %s %v", humanize.Bytes(free), err)
+	}
+	// volumes which happen to have the same free space are still counted separately
+	volumes := &TestFileSystem{Path: "/tmp", Volumes: map[string]string{"movies": "sda", "movies-4k": "sdb"}}
+	free, err = filesystem.GetTotalFreeSpace(volumes, playlist.GetBases())
+	if err != nil || free != humanize.GByte*200 {
+		t.Errorf("got free space %s %v", humanize.Bytes(free), err)
+	}
+	if capacity, err := filesystem.GetTotalCapacity(volumes, playlist.GetBases()); err != nil || capacity != humanize.GByte*1000 {
+		t.Errorf("got capacity %s %v", humanize.Bytes(capacity), err)
+	}
+}
+
+func TestMediaPathBases(t *testing.T) {
+	config := &models.Config{MediaFormat: models.MediaFormat{HeightFilter: 720}}
+	ctx := context.WithValue(context.Background(), "config", config)
+	item := client.Metadata{Media: []client.Media{
+		{Height: 2160, Part: []client.Part{{File: "/movies-4k/Dune (2021)/Dune (2021).mkv"}}},
+		{Height: 720, Part: []client.Part{{File: "/movies/Dune (2021)/Dune (2021).mkv"}}},
+	}}
+
+	if paths, _, _ := plex.GetMediaPath(&ctx, item, nil); paths[0] != "/movies/Dune (2021)/Dune (2021).mkv" {
+		t.Errorf("without bases got %v", paths)
+	}
+	if paths, _, _ := plex.GetMediaPath(&ctx, item, []string{"movies-4k"}); paths[0] != "/movies-4k/Dune (2021)/Dune (2021).mkv" {
+		t.Errorf("with bases got %v", paths)
+	}
+}
@@ -0,0 +1,127 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"plex-go-sync/internal/plex"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRetryTransientErrors(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch {
+		case r.URL.Path == "/library/sections" && requests == 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path == "/library/sections":
+			_, _ = w.Write([]byte(`{"MediaContainer": {"Directory": [{"key": "1", "title": "Movies"}]}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	p, err := plex.New(server.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	libraries, err := p.GetLibraries(&ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 || len(libraries.MediaContainer.Directory) != 1 {
+		t.Errorf("got %d requests and %d libraries", requests, len(libraries.MediaContainer.Directory))
+	}
+
+	requests = 0
+	_, err = p.GetItem(&ctx, "42")
+	if !plex.IsNotFound(err) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("not found should not be retried, got %d requests", requests)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := p.GetLibraries(&cancelled); err == nil {
+		t.Error("expected a cancelled request to fail")
+	}
+}
+
+func TestReplacePlaylist(t *testing.T) {
+	tests := []struct {
+		name       string
+		existing   bool
+		failAppend bool
+		want       []string
+	}{
+		// the old items stay until the new ones are all in, and the playlist keeps its rating key
+		{"update", true, false, []string{"GET /identity", "GET /playlists", "GET /playlists/1/items",
+			"PUT /playlists/1/items", "PUT /playlists/1/items", "DELETE /playlists/1/items/11",
+			"DELETE /playlists/1/items/12"}},
+		// a failed append is not retried, and only the added items are taken out again
+		{"failed update", true, true, []string{"GET /identity", "GET /playlists", "GET /playlists/1/items",
+			"PUT /playlists/1/items", "PUT /playlists/1/items", "GET /playlists/1/items",
+			"DELETE /playlists/1/items/13"}},
+		{"create", false, false, []string{"GET /identity", "GET /playlists", "POST /playlists",
+			"PUT /playlists/2/items"}},
+		{"failed create", false, true, []string{"GET /identity", "GET /playlists", "POST /playlists",
+			"PUT /playlists/2/items", "DELETE /playlists/2"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests []string
+			entries := `{"playlistItemID": 11}, {"playlistItemID": 12}`
+			puts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r.Method+" "+r.URL.Path)
+				switch {
+				case r.URL.Path == "/identity":
+					_, _ = w.Write([]byte(`{"MediaContainer": {"machineIdentifier": "abc"}}`))
+				case r.Method == "GET" && r.URL.Path == "/playlists" && test.existing:
+					_, _ = w.Write([]byte(`{"MediaContainer": {"Metadata": [{"ratingKey": "1", "title": "Travel"}]}}`))
+				case r.Method == "GET" && r.URL.Path == "/playlists":
+					_, _ = w.Write([]byte(`{"MediaContainer": {}}`))
+				case r.Method == "GET" && r.URL.Path == "/playlists/1/items":
+					_, _ = w.Write([]byte(`{"MediaContainer": {"Metadata": [` + entries + `]}}`))
+				case r.Method == "POST" && r.URL.Path == "/playlists":
+					_, _ = w.Write([]byte(`{"MediaContainer": {"Metadata": [{"ratingKey": "2", "title": "Travel"}]}}`))
+				case r.Method == "PUT":
+					puts++
+					if test.failAppend && (puts == 2 || !test.existing) {
+						w.WriteHeader(http.StatusInternalServerError)
+						return
+					}
+					entries += `, {"playlistItemID": 13}`
+				}
+			}))
+			defer server.Close()
+
+			p, err := plex.New(server.URL, "token")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ratingKeys := make([]string, 150)
+			for i := range ratingKeys {
+				ratingKeys[i] = strconv.Itoa(i)
+			}
+			ctx := context.Background()
+			err = p.ReplacePlaylist(&ctx, "Travel", "video", ratingKeys)
+			if test.failAppend && err == nil {
+				t.Error("expected the failed append to fail the replace")
+			} else if !test.failAppend && err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(requests, ", ") != strings.Join(test.want, ", ") {
+				t.Errorf("got requests %v, want %v", requests, test.want)
+			}
+		})
+	}
+}
@@ -19,17 +19,26 @@ func (discardCloser) Close() error {
 }
 
 type TestFileSystem struct {
-	Path string
+	Path    string
+	Volumes map[string]string // volume of each base, all bases are on one volume by default
 }
 
 func NewTestFileSystem(dir string) filesystem.FileSystem {
-	return TestFileSystem{Path: dir}
+	return &TestFileSystem{Path: dir}
 }
 
 func (f *TestFileSystem) GetFreeSpace(base string) (uint64, error) {
 	return humanize.GByte * 100, nil
 }
 
+func (f *TestFileSystem) GetCapacity(base string) (uint64, error) {
+	return humanize.GByte * 500, nil
+}
+
+func (f *TestFileSystem) GetVolume(base string) (string, error) {
+	return f.Volumes[base], nil
+}
+
 func (f *TestFileSystem) GetFileSystem(base string) (fs.FS, error) {
 	return os.DirFS("/tmp"), nil
 }
@@ -0,0 +1,37 @@
+package test
+
+import (
+	"net"
+	"plex-go-sync/internal/plex"
+	"testing"
+)
+
+func TestParseGDMResponse(t *testing.T) {
+	from := &net.UDPAddr{IP: net.ParseIP("192.168.1.20"), Port: 32414}
+	cases := []struct {
+		name     string
+		response string
+		ok       bool
+		want     plex.DiscoveredServer
+	}{
+		{"server", "HTTP/1.0 200 OK\r\nContent-Type: plex/media-server\r\nName: pi\r\nPort: 32400\r\n" +
+			"Resource-Identifier: abc\r\nVersion: 1.32.0\r\n\r\n", true,
+			plex.DiscoveredServer{Name: "pi", MachineIdentifier: "abc", Address: "192.168.1.20", Port: 32400, Version: "1.32.0"}},
+		{"other port", "HTTP/1.0 200 OK\r\nName: nas\r\nPort: 32500\r\nResource-Identifier: def\r\n\r\n", true,
+			plex.DiscoveredServer{Name: "nas", MachineIdentifier: "def", Address: "192.168.1.20", Port: 32500}},
+		{"no port", "HTTP/1.0 200 OK\r\nName: nas\r\n\r\n", true,
+			plex.DiscoveredServer{Name: "nas", Address: "192.168.1.20", Port: 32400}},
+		{"bad port", "HTTP/1.0 200 OK\r\nName: nas\r\nPort: many\r\n\r\n", true,
+			plex.DiscoveredServer{Name: "nas", Address: "192.168.1.20", Port: 32400}},
+		{"player", "HTTP/1.0 200 OK\r\nContent-Type: plex/media-player\r\nName: tv\r\n\r\n", false, plex.DiscoveredServer{}},
+		{"error status", "HTTP/1.0 404 Not Found\r\nName: pi\r\n\r\n", false, plex.DiscoveredServer{}},
+		{"not http", "hello", false, plex.DiscoveredServer{}},
+		{"empty", "", false, plex.DiscoveredServer{}},
+	}
+	for _, c := range cases {
+		server, ok := plex.ParseGDMResponse([]byte(c.response), from)
+		if ok != c.ok || server != c.want {
+			t.Errorf("%s: got %+v %v", c.name, server, ok)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"plex-go-sync/internal/plex"
+	"strconv"
+	"testing"
+)
+
+// pagingServer serves total items a page at a time, counting the requests
+func pagingServer(total int, withTotalSize bool, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		start, _ := strconv.Atoi(r.Header.Get("X-Plex-Container-Start"))
+		size, _ := strconv.Atoi(r.Header.Get("X-Plex-Container-Size"))
+		items := ""
+		for i := start; i < start+size && i < total; i++ {
+			if items != "" {
+				items += ","
+			}
+			items += fmt.Sprintf(`{"ratingKey": "%d"}`, i)
+		}
+		totalSize := ""
+		if withTotalSize {
+			totalSize = fmt.Sprintf(`"totalSize": %d, `, total)
+		}
+		_, _ = fmt.Fprintf(w, `{"MediaContainer": {%s"Metadata": [%s]}}`, totalSize, items)
+	}))
+}
+
+func TestMetadataIterator(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		total         int
+		withTotalSize bool
+		requests      int
+	}{
+		{"total size", 7, true, 3},
+		{"no total size", 7, false, 3},
+		// without a total size, the empty page after the last full one ends the items
+		{"no total size, full pages", 6, false, 3},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			requests := 0
+			server := pagingServer(test.total, test.withTotalSize, &requests)
+			defer server.Close()
+
+			p, err := plex.New(server.URL, "token")
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.PageSize = 3
+
+			ctx := context.Background()
+			items, err := p.IterateLibraryContent(&ctx, "1", "").All()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(items) != test.total {
+				t.Errorf("got %d items, want %d", len(items), test.total)
+			}
+			for i, item := range items {
+				if item.RatingKey != strconv.Itoa(i) {
+					t.Errorf("item %d has key %s", i, item.RatingKey)
+				}
+			}
+			if requests != test.requests {
+				t.Errorf("got %d requests, want %d", requests, test.requests)
+			}
+		})
+	}
+}
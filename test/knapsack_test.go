@@ -0,0 +1,154 @@
+package test
+
+import (
+	"github.com/dustin/go-humanize"
+	"os"
+	"path"
+	"plex-go-sync/internal/models"
+	"plex-go-sync/internal/selection"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func candidate(key string, size int64, hours int, fixed bool) selection.Candidate {
+	return selection.Candidate{
+		Item:  selection.Item{Keys: []string{key}, Value: models.PlaylistItem{Duration: time.Duration(hours) * time.Hour}},
+		Size:  size * humanize.GByte,
+		Fixed: fixed,
+	}
+}
+
+func packed(t *testing.T, candidates []selection.Candidate, budget int64, mode string) []string {
+	items, err := selection.Pack(candidates, budget*humanize.MByte, mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, item := range items {
+		keys = append(keys, item.Keys[0])
+	}
+	return keys
+}
+
+func TestPack(t *testing.T) {
+	candidates := []selection.Candidate{
+		candidate("a", 6, 1, false),
+		candidate("b", 5, 2, false),
+		candidate("c", 4, 1, false),
+		candidate("d", 3, 3, false),
+	}
+	if keys := packed(t, candidates, 10200, selection.PackHours); !reflect.DeepEqual(keys, []string{"b", "d"}) {
+		t.Errorf("hours gave %v", keys)
+	}
+	if keys := packed(t, candidates, 10200, selection.PackPriority); !reflect.DeepEqual(keys, []string{"a", "c"}) {
+		t.Errorf("priority gave %v", keys)
+	}
+
+	// files already copied are kept and use up the budget first
+	candidates[2].Fixed = true
+	if keys := packed(t, candidates, 10200, selection.PackHours); !reflect.DeepEqual(keys, []string{"c", "d"}) {
+		t.Errorf("hours with a fixed item gave %v", keys)
+	}
+
+	// greedy would take the first item and leave the rest of the space unused
+	small := []selection.Candidate{
+		candidate("a", 6, 1, false),
+		candidate("b", 2, 1, false),
+		candidate("c", 2, 1, false),
+		candidate("d", 2, 1, false),
+	}
+	if keys := packed(t, small, 7200, selection.PackItems); !reflect.DeepEqual(keys, []string{"b", "c", "d"}) {
+		t.Errorf("items gave %v", keys)
+	}
+
+	// an item of unknown size would weigh nothing and always be chosen
+	unknown := append([]selection.Candidate{candidate("x", 0, 5, false)}, small...)
+	if keys := packed(t, unknown, 7200, selection.PackHours); !reflect.DeepEqual(keys, []string{"b", "c", "d"}) {
+		t.Errorf("hours with an unknown size gave %v", keys)
+	}
+
+	if _, err := selection.Pack(candidates, 10, "size"); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
+
+func TestEstimateSize(t *testing.T) {
+	config := &models.Config{MediaFormat: models.MediaFormat{BitrateFilter: 3500 * humanize.KByte}}
+	small := models.PlaylistItem{Duration: time.Hour, Size: 1 * humanize.GByte, Bitrate: 2000}
+	if size := selection.EstimateSize(config, small); size != small.Size {
+		t.Errorf("small file estimated at %s", humanize.Bytes(uint64(size)))
+	}
+	large := models.PlaylistItem{Duration: time.Hour, Size: 10 * humanize.GByte, Bitrate: 20000}
+	if size := selection.EstimateSize(config, large); size != 3500*humanize.KByte/8*3600 {
+		t.Errorf("large file estimated at %s", humanize.Bytes(uint64(size)))
+	}
+	// without a size or bitrate, the item is assumed to be encoded at the full target bitrate
+	unknown := models.PlaylistItem{Duration: time.Hour}
+	if size := selection.EstimateSize(config, unknown); size != 3500*humanize.KByte/8*3600 {
+		t.Errorf("unknown size estimated at %s", humanize.Bytes(uint64(size)))
+	}
+	if size := selection.EstimateSize(config, models.PlaylistItem{}); size != 0 {
+		t.Errorf("unknown size and duration estimated at %s", humanize.Bytes(uint64(size)))
+	}
+}
+
+func TestPredictAction(t *testing.T) {
+	config := &models.Config{MediaFormat: models.MediaFormat{BitrateFilter: 3500 * humanize.KByte, HeightFilter: 1080, Format: "mp4"},
+		AudioFormat: models.AudioFormat{Format: "aac"}}
+	tests := []struct {
+		item   models.PlaylistItem
+		action string
+	}{
+		{models.PlaylistItem{Paths: []string{"/tv/a.mp4"}, Bitrate: 2000, Height: 720}, selection.ActionCopy},
+		{models.PlaylistItem{Paths: []string{"/tv/a.mkv"}, Bitrate: 2000, Height: 720}, selection.ActionRemux},
+		{models.PlaylistItem{Paths: []string{"/tv/a.mp4"}, Bitrate: 2000, Height: 720,
+			Parts: map[string][]string{"/tv/a.mp4": {"/tv/a-1.mp4", "/tv/a-2.mp4"}}}, selection.ActionRemux},
+		{models.PlaylistItem{Paths: []string{"/tv/a.mp4"}, Bitrate: 9000, Height: 720}, selection.ActionTranscode},
+		{models.PlaylistItem{Paths: []string{"/tv/a.mp4"}, Bitrate: 2000, Height: 2160}, selection.ActionTranscode},
+		{models.PlaylistItem{Paths: []string{"/music/a.flac"}, Type: "track", Codec: "flac", Bitrate: 900}, selection.ActionTranscode},
+	}
+	for _, test := range tests {
+		if action := selection.PredictAction(config, test.item); action != test.action {
+			t.Errorf("%s at %d kbps and %dp gave %s, expected %s", test.item.Paths[0], test.item.Bitrate,
+				test.item.Height, action, test.action)
+		}
+	}
+}
+
+func TestSizeHistory(t *testing.T) {
+	dir := t.TempDir()
+	config := &models.Config{Path: path.Join(dir, "configs.json"), MediaFormat: models.MediaFormat{BitrateFilter: 3500 * humanize.KByte, HeightFilter: 1080, Format: "mp4"}}
+	item := models.PlaylistItem{Paths: []string{"/tv/a.mkv"}, Duration: time.Hour, Size: 10 * humanize.GByte, Bitrate: 20000, Height: 2160}
+	raw := selection.EstimateSize(config, item)
+
+	sizes := selection.LoadSizeHistory(config)
+	if size := sizes.Estimate(config, item); size != raw {
+		t.Errorf("estimate without history was %s", humanize.Bytes(uint64(size)))
+	}
+	sizes.Record(config, item, raw/2)
+	sizes.Skip(raw)
+
+	// the ratio is saved, so the next run starts from it
+	if _, err := os.Stat(path.Join(dir, "size-history.json")); err != nil {
+		t.Errorf("size history was not saved next to the config: %v", err)
+	}
+	sizes = selection.LoadSizeHistory(config)
+	if size := sizes.Estimate(config, item); size != raw/2 {
+		t.Errorf("estimate after a copy half the size was %s", humanize.Bytes(uint64(size)))
+	}
+	sizes.Record(config, item, raw)
+	if size := sizes.Estimate(config, item); size != raw*5/8 {
+		t.Errorf("estimate after a full size copy was %s", humanize.Bytes(uint64(size)))
+	}
+
+	// other profiles are not affected
+	remux := models.PlaylistItem{Paths: []string{"/tv/b.mkv"}, Duration: time.Hour, Size: humanize.GByte, Bitrate: 2000, Height: 720}
+	if size := sizes.Estimate(config, remux); size != remux.Size {
+		t.Errorf("remux estimate was %s", humanize.Bytes(uint64(size)))
+	}
+	if summary := sizes.Summary(); !strings.Contains(summary, "1 items copied") {
+		t.Errorf("summary was %s", summary)
+	}
+}
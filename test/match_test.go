@@ -0,0 +1,86 @@
+package test
+
+import (
+	client "github.com/jrudio/go-plex-client"
+	"plex-go-sync/internal/actions/sync"
+	"plex-go-sync/internal/models"
+	"testing"
+)
+
+func movie(ratingKey string, guid string, title string, year int, file string, altGuids ...string) client.Metadata {
+	item := client.Metadata{RatingKey: ratingKey, GUID: guid, Title: title, Year: year}
+	for _, id := range altGuids {
+		item.AltGUIDs = append(item.AltGUIDs, client.AltGUID{ID: id})
+	}
+	if file != "" {
+		item.Media = []client.Media{{Part: []client.Part{{File: file}}}}
+	}
+	return item
+}
+
+func TestMatcher(t *testing.T) {
+	source := []client.Metadata{
+		movie("1", "plex://movie/heat", "Heat", 1995, "/movies/Heat (1995)/Heat (1995).mkv"),
+		movie("2", "local://2", "Dune", 2021, "/mnt/storage/movies/Dune (2021)/Dune (2021).mkv", "imdb://tt1160419"),
+		movie("3", "local://3", "Alien", 1979, "/movies/Alien (1979)/Alien (1979).mkv"),
+		movie("4", "local://4", "Brazil", 1985, ""),
+		// the same film twice, so neither can be chosen by title
+		movie("5", "local://5", "Solaris", 1972, ""),
+		movie("6", "local://6", "Solaris", 1972, ""),
+		// two items sharing an external guid
+		movie("7", "local://7", "Casablanca", 1942, "", "tmdb://289"),
+		movie("8", "local://8", "Casablanca", 1942, "", "tmdb://289"),
+	}
+	rules := models.PathRules{{From: "/mnt/storage/movies", To: "/movies"}}
+	matcher := sync.NewMatcher(source, rules, nil, sync.TitleYearKey)
+
+	cases := []struct {
+		name   string
+		item   client.Metadata
+		result sync.MatchResult
+		want   string
+	}{
+		{"guid", movie("a", "plex://movie/heat", "Heat (Director's Cut)", 1995, ""), sync.MatchFound, "1"},
+		{"external guid", movie("b", "local://b", "Dune: Part One", 2021, "", "imdb://tt1160419"), sync.MatchFound, "2"},
+		{"file key after path rules", movie("c", "local://c", "Dune", 2020, "/movies/Dune (2021)/Dune (2021).mp4"), sync.MatchFound, "2"},
+		{"file key", movie("d", "local://d", "Alien (Remastered)", 1979, "/movies/Alien (1979)/Alien (1979).mkv"), sync.MatchFound, "3"},
+		{"title and year", movie("e", "local://e", "BRAZIL", 1985, ""), sync.MatchFound, "4"},
+		{"local guids are not shared", movie("f", "local://4", "Brazil", 1986, ""), sync.MatchNone, ""},
+		{"other year", movie("g", "local://g", "Heat", 1986, ""), sync.MatchNone, ""},
+		{"ambiguous title", movie("h", "local://h", "Solaris", 1972, ""), sync.MatchAmbiguous, ""},
+		{"ambiguous external guid", movie("i", "local://i", "Casablanca", 1942, "", "tmdb://289"), sync.MatchAmbiguous, ""},
+		// a stronger tier wins, so the title of another movie doesn't make the match ambiguous
+		{"stronger tier first", movie("j", "plex://movie/heat", "Solaris", 1972, ""), sync.MatchFound, "1"},
+	}
+	for _, c := range cases {
+		item, result := matcher.Match(c.item)
+		if result != c.result || item.RatingKey != c.want {
+			t.Errorf("%s: got %v %q, want %v %q", c.name, result, item.RatingKey, c.result, c.want)
+		}
+	}
+}
+
+func TestEpisodeKey(t *testing.T) {
+	cases := []struct {
+		item client.Metadata
+		want string
+	}{
+		{client.Metadata{ParentIndex: 1, Index: 2}, "s01e02"},
+		{client.Metadata{ParentIndex: 0, Index: 3}, "s00e03"},
+		{client.Metadata{ParentIndex: 12, Index: 104}, "s12e104"},
+		{client.Metadata{ParentIndex: 1}, ""},
+		{client.Metadata{}, ""},
+	}
+	for _, c := range cases {
+		if got := sync.EpisodeKey(c.item); got != c.want {
+			t.Errorf("season %d episode %d: got %q, want %q", c.item.ParentIndex, c.item.Index, got, c.want)
+		}
+	}
+
+	// episodes without a number are not matched with each other
+	source := []client.Metadata{{RatingKey: "1", GUID: "local://1", ParentIndex: 1}}
+	matcher := sync.NewMatcher(source, nil, nil, sync.EpisodeKey)
+	if _, result := matcher.Match(client.Metadata{GUID: "local://2", ParentIndex: 1}); result != sync.MatchNone {
+		t.Errorf("got %v for an episode without a number", result)
+	}
+}
@@ -0,0 +1,54 @@
+package test
+
+import (
+	"context"
+	client "github.com/jrudio/go-plex-client"
+	"plex-go-sync/internal/models"
+	"plex-go-sync/internal/plex"
+	"testing"
+	"time"
+)
+
+func TestStackedPath(t *testing.T) {
+	tests := map[string]string{
+		"/movies/Heat (1995)/Heat (1995) - pt1.mkv": "/movies/Heat (1995)/Heat (1995).mkv",
+		"/movies/Heat (1995)/Heat (1995) - CD1.avi": "/movies/Heat (1995)/Heat (1995).avi",
+		"/tv/Show/Show - s01e01 - part1.mkv":        "/tv/Show/Show - s01e01.mkv",
+		"/movies/Heat/Heat.disc1.mkv":               "/movies/Heat/Heat.mkv",
+		"/movies/Heat/pt1.mkv":                      "/movies/Heat/pt1.mkv",
+		"/movies/Heat/Heat A.mkv":                   "/movies/Heat/Heat A.mkv",
+	}
+	for first, want := range tests {
+		if got := plex.StackedPath([]string{first, "second"}); got != want {
+			t.Errorf("%s: got %s, want %s", first, got, want)
+		}
+	}
+}
+
+func TestMultiPartMediaPath(t *testing.T) {
+	config := &models.Config{MediaFormat: models.MediaFormat{HeightFilter: 720}}
+	ctx := context.WithValue(context.Background(), "config", config)
+	item := client.Metadata{Media: []client.Media{{
+		Height:   720,
+		Duration: 7200000,
+		Part: []client.Part{
+			{File: "/movies/Heat (1995)/Heat (1995) - pt1.mkv"},
+			{File: "/movies/Heat (1995)/Heat (1995) - pt2.mkv"},
+		},
+	}}}
+
+	paths, parts, duration := plex.GetMediaPath(&ctx, item, nil)
+	if len(paths) != 1 || paths[0] != "/movies/Heat (1995)/Heat (1995).mkv" {
+		t.Fatalf("got paths %v", paths)
+	}
+	if duration != 2*time.Hour {
+		t.Errorf("got duration %s", duration)
+	}
+	playlistItem := models.PlaylistItem{Paths: paths, Parts: parts}
+	if got := playlistItem.GetParts(paths[0]); len(got) != 2 || got[1] != "/movies/Heat (1995)/Heat (1995) - pt2.mkv" {
+		t.Errorf("got parts %v", got)
+	}
+	if key := plex.GetKey(paths[0]); key != "Heat (1995)/Heat (1995)" {
+		t.Errorf("got key %s", key)
+	}
+}
@@ -0,0 +1,82 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	client "github.com/jrudio/go-plex-client"
+	"net/http"
+	"net/http/httptest"
+	"plex-go-sync/internal/models"
+	"plex-go-sync/internal/plex"
+	"testing"
+)
+
+func TestAudioFormat(t *testing.T) {
+	aac := models.AudioFormat{Format: "aac", Bitrate: "256k"}
+	if aac.Extension() != "m4a" || aac.Codec() != "aac" || aac.GetBitrate() != 256000 {
+		t.Errorf("unexpected aac format %s %s %d", aac.Extension(), aac.Codec(), aac.GetBitrate())
+	}
+	opus := models.AudioFormat{Format: "opus", Bitrate: "128kbps"}
+	if opus.Extension() != "opus" || opus.Codec() != "libopus" || opus.GetBitrate() != 128000 {
+		t.Errorf("unexpected opus format %s %s %d", opus.Extension(), opus.Codec(), opus.GetBitrate())
+	}
+}
+
+func TestSyncTrack(t *testing.T) {
+	scrobbles := make(map[string]int)
+	ratings := make(map[string]string)
+	failScrobble := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/:/scrobble":
+			scrobbles[r.URL.Query().Get("key")]++
+			if scrobbles[r.URL.Query().Get("key")] == failScrobble {
+				w.WriteHeader(http.StatusBadGateway)
+			}
+		case "/:/rate":
+			ratings[r.URL.Query().Get("key")] = r.URL.Query().Get("rating")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	p, err := plex.New(server.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	src := client.Metadata{RatingKey: "1", ViewCount: json.Number("3")}
+	dest := client.Metadata{RatingKey: "2", ViewCount: json.Number("1")}
+
+	direction, err := plex.SyncTrack(&ctx, p, src, plex.Rating{UserRating: 8, LastRatedAt: 10}, p, dest,
+		plex.Rating{UserRating: 4, LastRatedAt: 20}, false, plex.NewestWins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if direction != plex.ToDestination || scrobbles["2"] != 2 || ratings["2"] != "8" {
+		t.Errorf("one way: got %v, scrobbles %v, ratings %v", direction, scrobbles, ratings)
+	}
+
+	// both ways, the newer destination rating wins but the play count still goes to the destination
+	scrobbles = make(map[string]int)
+	ratings = make(map[string]string)
+	direction, err = plex.SyncTrack(&ctx, p, src, plex.Rating{UserRating: 8, LastRatedAt: 10}, p, dest,
+		plex.Rating{UserRating: 4, LastRatedAt: 20}, true, plex.NewestWins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if direction != plex.ToSource || scrobbles["2"] != 2 || ratings["1"] != "4" {
+		t.Errorf("two way: got %v, scrobbles %v, ratings %v", direction, scrobbles, ratings)
+	}
+
+	// a scrobble the server may have counted is not retried, since that would add another play
+	scrobbles = make(map[string]int)
+	failScrobble = 1
+	if _, err := plex.SyncTrack(&ctx, p, src, plex.Rating{}, p, dest, plex.Rating{}, false, plex.NewestWins); err == nil {
+		t.Error("expected the failed scrobble to fail the sync")
+	}
+	if scrobbles["2"] != 1 {
+		t.Errorf("failed scrobble: got scrobbles %v", scrobbles)
+	}
+}
@@ -0,0 +1,100 @@
+package test
+
+import (
+	"context"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"plex-go-sync/internal/plex"
+	"testing"
+	"time"
+)
+
+func TestRefreshLibrariesNotifications(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	refreshed := make(chan bool, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/:/websockets/notifications":
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			//goland:noinspection GoUnhandledErrorResult
+			defer conn.Close()
+			<-refreshed
+			for _, event := range []string{"started", "ended"} {
+				_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"NotificationContainer": {"type": "activity",
+					"ActivityNotification": [{"event": "`+event+`", "Activity": {"type": "library.update.section",
+					"Context": {"librarySectionID": "2"}}}]}}`))
+			}
+			_, _, _ = conn.ReadMessage()
+		case "/library/sections/all/refresh":
+			refreshed <- true
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	p, err := plex.New(server.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	select {
+	case key := <-p.RefreshLibraries(&ctx):
+		if key != "2" {
+			t.Errorf("got section %s, want 2", key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("scan completion was not reported")
+	}
+}
+
+func TestWaitForScans(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	refreshed := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/:/websockets/notifications":
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			//goland:noinspection GoUnhandledErrorResult
+			defer conn.Close()
+			// the end of a partial scan which is already running
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"NotificationContainer": {"type": "activity",
+				"ActivityNotification": [{"event": "ended", "Activity": {"type": "library.update.section",
+				"Context": {"librarySectionID": "3"}}}]}}`))
+			_, _, _ = conn.ReadMessage()
+		case "/library/sections/all/refresh":
+			refreshed = true
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	p, err := plex.New(server.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	select {
+	case key := <-p.WaitForScans(&ctx):
+		if key != "3" {
+			t.Errorf("got section %s, want 3", key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("scan completion was not reported")
+	}
+	if refreshed {
+		t.Error("waiting for scans should not refresh every library")
+	}
+}
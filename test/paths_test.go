@@ -0,0 +1,39 @@
+package test
+
+import (
+	"plex-go-sync/internal/models"
+	"testing"
+)
+
+func TestPathRules(t *testing.T) {
+	rules := models.PathRules{
+		{From: "/mnt/storage", To: "/"},
+		{From: "/mnt/storage/tv/", To: "/tv"},
+		{From: `D:\Media\movies`, To: "/movies"},
+	}
+	tests := []struct {
+		plex string
+		file string
+	}{
+		{"/mnt/storage/tv/Show/Season 01/Show - s01e01.mkv", "/tv/Show/Season 01/Show - s01e01.mkv"},
+		{"/mnt/storage/music/Artist/Album/01 - Track.flac", "/music/Artist/Album/01 - Track.flac"},
+		{`D:\Media\movies\Heat (1995)\Heat (1995).mkv`, "/movies/Heat (1995)/Heat (1995).mkv"},
+		{"/mnt/storagebox/movies/Heat.mkv", "/mnt/storagebox/movies/Heat.mkv"},
+		{"/media/movies/Heat.mkv", "/media/movies/Heat.mkv"},
+	}
+	for _, test := range tests {
+		if file := rules.Map(test.plex); file != test.file {
+			t.Errorf("map %s: got %s, want %s", test.plex, file, test.file)
+		}
+	}
+
+	if plex, ok := rules.Unmap("/tv/Show/Show - s01e01.mp4"); !ok || plex != "/mnt/storage/tv/Show/Show - s01e01.mp4" {
+		t.Errorf("unmap tv: got %s %v", plex, ok)
+	}
+	if plex, ok := rules.Unmap("/movies/Heat (1995)/Heat (1995).mp4"); !ok || plex != `D:\Media\movies\Heat (1995)\Heat (1995).mp4` {
+		t.Errorf("unmap movies: got %s %v", plex, ok)
+	}
+	if rule, ok := models.PathRules(nil).Find("/media/movies/Heat.mkv"); ok {
+		t.Errorf("no rules should not match, got %v", rule)
+	}
+}
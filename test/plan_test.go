@@ -0,0 +1,80 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/dustin/go-humanize"
+	"plex-go-sync/internal/actions/plan"
+	"plex-go-sync/internal/models"
+	"plex-go-sync/internal/plex"
+	"plex-go-sync/internal/selection"
+	"plex-go-sync/internal/structures"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlanCopies(t *testing.T) {
+	config := &models.Config{MediaFormat: models.MediaFormat{Format: "mp4", BitrateFilter: 8000000, HeightFilter: 1080}}
+	items := structures.NewOrderedMap[models.PlaylistItem]()
+	for _, item := range []struct {
+		name string
+		size int64
+	}{{"Alien", 3 * humanize.GByte}, {"Brazil", 4 * humanize.GByte}, {"Casablanca", 2.5 * humanize.GByte},
+		{"Dune", 2 * humanize.GByte}, {"Heat", humanize.GByte}} {
+		file := "/movies/" + item.name + "/" + item.name + ".mp4"
+		items.Set(plex.GetKey(file), models.PlaylistItem{Paths: []string{file}, Size: item.size, Bitrate: 4000,
+			Height: 720, Duration: time.Hour})
+	}
+	playlist := &models.Playlist{Name: "Movies", Items: items}
+	existing := map[string]uint64{"Alien/Alien": 3 * humanize.GByte, "Dune/Dune": 2 * humanize.GByte}
+	deletes := []plan.Row{{Action: selection.ActionDelete, Destination: "movies/Old/Old.mp4", Size: 700 * humanize.MByte}}
+
+	// like the clone, Brazil fits in what is left, Casablanca only fits after removing the copy of Dune at the end
+	// of the playlist and Heat doesn't fit at all
+	result := plan.Copies(config, playlist, existing, 5*humanize.GByte, 10*humanize.GByte, deletes, nil)
+	want := []plan.Row{
+		{Action: selection.ActionKeep, Source: "/movies/Alien/Alien.mp4", Destination: "/movies/Alien/Alien.mp4", Size: 3 * humanize.GByte},
+		{Action: selection.ActionCopy, Source: "/movies/Brazil/Brazil.mp4", Destination: "/movies/Brazil/Brazil.mp4", Size: 4 * humanize.GByte},
+		{Action: selection.ActionCopy, Source: "/movies/Casablanca/Casablanca.mp4", Destination: "/movies/Casablanca/Casablanca.mp4", Size: 2.5 * humanize.GByte},
+		{Action: selection.ActionDelete, Source: "/movies/Dune/Dune.mp4", Destination: "/movies/Dune/Dune.mp4", Size: 2 * humanize.GByte},
+		deletes[0],
+	}
+	if !reflect.DeepEqual(result.Rows, want) {
+		t.Errorf("got rows %+v", result.Rows)
+	}
+	totals := map[string]plan.Total{
+		selection.ActionKeep:   {Items: 1, Size: 3 * humanize.GByte},
+		selection.ActionCopy:   {Items: 2, Size: 6.5 * humanize.GByte},
+		selection.ActionDelete: {Items: 2, Size: 2*humanize.GByte + 700*humanize.MByte},
+	}
+	if !reflect.DeepEqual(result.Totals, totals) || result.Skipped != 1 || result.Budget != 10*humanize.GByte {
+		t.Errorf("got totals %+v, %d skipped and a budget of %d", result.Totals, result.Skipped, result.Budget)
+	}
+
+	var out bytes.Buffer
+	if err := plan.Write(&out, []plan.PlaylistPlan{result}, true); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []plan.PlaylistPlan
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 1 || !reflect.DeepEqual(decoded[0], result) {
+		t.Errorf("got json %s", out.String())
+	}
+	for _, field := range []string{`"action": "keep"`, `"destination": "movies/Old/Old.mp4"`, `"skipped": 1`, `"budget": 10000000000`} {
+		if !strings.Contains(out.String(), field) {
+			t.Errorf("json is missing %s", field)
+		}
+	}
+
+	out.Reset()
+	if err := plan.Write(&out, []plan.PlaylistPlan{result}, false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "copy 2 (6.5 GB), delete 2 (2.7 GB), 1 items don't fit, budget 10 GB") {
+		t.Errorf("got table %s", out.String())
+	}
+}
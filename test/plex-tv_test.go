@@ -0,0 +1,114 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"plex-go-sync/internal/models"
+	"plex-go-sync/internal/plex"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPinFlow(t *testing.T) {
+	checks := 0
+	dir := t.TempDir()
+	file := path.Join(dir, "configs.json")
+	_ = os.WriteFile(file, []byte(`{"sourceServer": "http://localhost:32400", "destinationConnection": {"url": "http://travel:32400"}, "playlists": [{"name": "TV", "seed": 9007199254740993}]}`), 0600)
+	identifier, err := models.ClientIdentifier(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Plex-Client-Identifier") != identifier {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/api/v2/pins":
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"id": 42, "code": "ABCD", "expiresIn": 60}`))
+		case r.Method == "GET" && r.URL.Path == "/api/v2/pins/42":
+			checks++
+			if checks < 2 {
+				_, _ = w.Write([]byte(`{"id": 42, "code": "ABCD", "authToken": null}`))
+			} else {
+				_, _ = w.Write([]byte(`{"id": 42, "code": "ABCD", "authToken": "secret"}`))
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	client := plex.NewPinClient(server.URL, identifier)
+	pin, err := client.RequestPin(&ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pin.Code != "ABCD" {
+		t.Errorf("got code %s", pin.Code)
+	}
+
+	token, err := client.WaitForToken(&ctx, pin, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "secret" {
+		t.Errorf("got token %s", token)
+	}
+
+	if err := models.WriteToken(file, "destination", token, identifier); err != nil {
+		t.Fatal(err)
+	}
+	var config models.Config
+	data, _ := os.ReadFile(file)
+	if err := json.Unmarshal(data, &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Server != "http://localhost:32400" || config.DestinationConnection.Token != "secret" ||
+		config.DestinationConnection.URL != "http://travel:32400" {
+		t.Errorf("unexpected config %+v", config)
+	}
+	// the rest of the file is kept as it was, in the same order
+	if config.Playlists[0].Seed != 9007199254740993 {
+		t.Errorf("seed changed to %d", config.Playlists[0].Seed)
+	}
+	if strings.Index(string(data), "sourceServer") > strings.Index(string(data), "playlists") {
+		t.Errorf("settings were reordered:\n%s", data)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("got %d files, the temporary file should have been renamed", len(files))
+	}
+}
+
+func TestClientIdentifier(t *testing.T) {
+	dir := t.TempDir()
+	first, err := models.ClientIdentifier(path.Join(dir, "credentials.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := models.ClientIdentifier(path.Join(dir, "other.json"))
+	if len(first) != 36 || first == second {
+		t.Errorf("installs got the identifiers %s and %s", first, second)
+	}
+
+	// once a token is stored, the install keeps its identifier
+	file := path.Join(dir, "credentials.json")
+	if err := models.WriteToken(file, "", "secret", first); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := models.ClientIdentifier(file); err != nil || stored != first {
+		t.Errorf("got identifier %s %v, want %s", stored, err, first)
+	}
+
+	_ = os.WriteFile(file, []byte(`{"token": `), 0600)
+	if _, err := models.ClientIdentifier(file); err == nil {
+		t.Error("expected a malformed file to fail")
+	}
+}
@@ -0,0 +1,58 @@
+package test
+
+import (
+	client "github.com/jrudio/go-plex-client"
+	"plex-go-sync/internal/models"
+	"plex-go-sync/internal/plex"
+	"testing"
+)
+
+func TestFindSectionFolder(t *testing.T) {
+	sections := []client.Directory{
+		{Key: "1", Location: []client.Location{{Path: "/mnt/usb/movies"}}},
+		{Key: "2", Location: []client.Location{{Path: "/mnt/usb/other"}, {Path: `D:\Media\tv\`}}},
+	}
+	tests := []struct {
+		file    string
+		section string
+		folder  string
+		found   bool
+	}{
+		{"movies/Heat (1995)/Heat (1995).mp4", "1", "/mnt/usb/movies/Heat (1995)", true},
+		{"/tv/Show/Season 01/Show - s01e01.mp4", "2", `D:\Media\tv\Show\Season 01`, true},
+		{"/mnt/usb/movies/Heat (1995)/Heat (1995).mp4", "1", "/mnt/usb/movies/Heat (1995)", true},
+		{`D:\Media\tv\Show\Season 01\Show - s01e01.mp4`, "2", `D:\Media\tv\Show\Season 01`, true},
+		{"music/Album/Track.mp3", "", "", false},
+		{"loose.mp4", "", "", false},
+	}
+	for _, test := range tests {
+		section, folder, found := plex.FindSectionFolder(sections, test.file)
+		if section != test.section || folder != test.folder || found != test.found {
+			t.Errorf("%s: got %s %s %v", test.file, section, folder, found)
+		}
+	}
+}
+
+func TestFindLocalFile(t *testing.T) {
+	sections := []client.Directory{
+		{Key: "1", Location: []client.Location{{Path: "/mnt/usb/movies"}}},
+		{Key: "2", Location: []client.Location{{Path: `D:\Media\tv\`}}},
+	}
+	rules := models.PathRules{{From: "/data/kids", To: "/movies/kids"}}
+	tests := []struct {
+		file  string
+		local string
+		found bool
+	}{
+		{"/mnt/usb/movies/Heat (1995)/Heat (1995).mp4", "/movies/Heat (1995)/Heat (1995).mp4", true},
+		{`D:\Media\tv\Show\Season 01\Show - s01e01.mp4`, "/tv/Show/Season 01/Show - s01e01.mp4", true},
+		{"/data/kids/Up (2009)/Up (2009).mp4", "/movies/kids/Up (2009)/Up (2009).mp4", true},
+		{"/elsewhere/loose.mp4", "", false},
+	}
+	for _, test := range tests {
+		local, found := plex.FindLocalFile(sections, rules, test.file)
+		if local != test.local || found != test.found {
+			t.Errorf("%s: got %s %v", test.file, local, found)
+		}
+	}
+}
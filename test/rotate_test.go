@@ -0,0 +1,84 @@
+package test
+
+import (
+	"context"
+	"errors"
+	client "github.com/jrudio/go-plex-client"
+	"os"
+	"path"
+	"plex-go-sync/internal/actions/sync"
+	"plex-go-sync/internal/filesystem"
+	"plex-go-sync/internal/models"
+	"plex-go-sync/internal/structures"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWatchedBefore(t *testing.T) {
+	now := time.Now()
+	cutoff := now.AddDate(0, 0, -7)
+	items := []client.Metadata{
+		{RatingKey: "old", Type: "episode", ViewCount: "1", LastViewedAt: int(now.AddDate(0, 0, -30).Unix())},
+		{RatingKey: "cutoff", Type: "movie", ViewCount: "2", LastViewedAt: int(cutoff.Unix())},
+		{RatingKey: "recent", Type: "movie", ViewCount: "1", LastViewedAt: int(now.AddDate(0, 0, -1).Unix())},
+		{RatingKey: "unwatched", Type: "movie", LastViewedAt: int(now.AddDate(0, 0, -30).Unix())},
+		// watched before, but being watched again
+		{RatingKey: "partial", Type: "episode", ViewCount: "1", ViewOffset: 60000,
+			LastViewedAt: int(now.AddDate(0, 0, -30).Unix())},
+		{RatingKey: "track", Type: "track", ViewCount: "5", LastViewedAt: int(now.AddDate(0, 0, -30).Unix())},
+	}
+	var keys []string
+	for _, item := range sync.WatchedBefore(items, cutoff) {
+		keys = append(keys, item.RatingKey)
+	}
+	if !reflect.DeepEqual(keys, []string{"old", "cutoff"}) {
+		t.Errorf("got %v", keys)
+	}
+}
+
+func TestRemoveRotations(t *testing.T) {
+	root := t.TempDir()
+	for _, file := range []string{"tv/a.mp4", "tv/b.mp4"} {
+		if err := os.MkdirAll(path.Join(root, path.Dir(file)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path.Join(root, file), []byte("video"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rotations := []sync.Rotation{
+		{Item: client.Metadata{Title: "A"}, Source: client.Metadata{RatingKey: "1"}, Files: []string{"tv/a.mp4"}},
+		{Item: client.Metadata{Title: "B"}, Source: client.Metadata{RatingKey: "2"}, Files: []string{"tv/b.mp4"}},
+	}
+
+	ctx := context.Background()
+	removed, rotated := sync.RemoveRotations(&ctx, filesystem.NewLocalFileSystem(root), rotations,
+		func(rotation sync.Rotation) error {
+			if rotation.Item.Title == "B" {
+				return errors.New("source unreachable")
+			}
+			return nil
+		})
+
+	// the play state of B couldn't be synced, so it stays on the destination
+	if !reflect.DeepEqual(removed, []string{"tv/a.mp4"}) || !reflect.DeepEqual(rotated, map[string]bool{"1": true}) {
+		t.Errorf("removed %v, rotated %v", removed, rotated)
+	}
+	if _, err := os.Stat(path.Join(root, "tv/a.mp4")); !os.IsNotExist(err) {
+		t.Error("a.mp4 was not removed")
+	}
+	if _, err := os.Stat(path.Join(root, "tv/b.mp4")); err != nil {
+		t.Error("b.mp4 was removed")
+	}
+
+	// only the item rotated out is left out of the playlist, other watched items are still copied
+	items := structures.NewOrderedMap[models.PlaylistItem]()
+	items.Set("a", models.PlaylistItem{RatingKey: "1", ViewCount: 1})
+	items.Set("b", models.PlaylistItem{RatingKey: "2", ViewCount: 1})
+	items.Set("c", models.PlaylistItem{RatingKey: "3", ViewCount: 1})
+	kept := sync.WithoutRotated(&items, rotated)
+	if keys := kept.Keys(); !reflect.DeepEqual(keys, []string{"b", "c"}) {
+		t.Errorf("kept %v", keys)
+	}
+}
@@ -0,0 +1,151 @@
+package test
+
+import (
+	"fmt"
+	"math/rand"
+	"plex-go-sync/internal/models"
+	"plex-go-sync/internal/selection"
+	"plex-go-sync/internal/structures"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testPlaylist() *structures.OrderedMap[models.PlaylistItem] {
+	items := structures.NewOrderedMap[models.PlaylistItem]()
+	for i := 0; i < 12; i++ {
+		items.Set(fmt.Sprint(i), models.PlaylistItem{
+			Type:      "episode",
+			Parent:    fmt.Sprint("Show ", i%3),
+			ViewCount: i % 2,
+			AddedAt:   i,
+		})
+	}
+	return &items
+}
+
+func order(t *testing.T, name string, seed int64, history *selection.History) []string {
+	strategy, err := selection.Get(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &selection.Env{Random: rand.New(rand.NewSource(seed)), History: history}
+	ordered := selection.Apply(testPlaylist(), strategy, env)
+	return ordered.Keys()
+}
+
+func TestRandomOrderSeed(t *testing.T) {
+	first := order(t, selection.OrderRandom, 42, nil)
+	if again := order(t, selection.OrderRandom, 42, nil); !reflect.DeepEqual(first, again) {
+		t.Errorf("same seed gave %v and %v", first, again)
+	}
+	if other := order(t, selection.OrderRandom, 43, nil); reflect.DeepEqual(first, other) {
+		t.Errorf("different seeds gave the same order %v", first)
+	}
+
+	// episodes are picked from each show in turn
+	items := testPlaylist()
+	for i := 0; i+2 < len(first); i += 3 {
+		shows := make(map[string]bool)
+		for _, key := range first[i : i+3] {
+			item, _ := items.Get(key)
+			shows[item.Parent] = true
+		}
+		if len(shows) != 3 {
+			t.Errorf("round %d does not have every show: %v", i/3, first)
+		}
+	}
+}
+
+func TestOrderStrategies(t *testing.T) {
+	if keys := order(t, selection.OrderUnwatchedFirst, 1, nil); !reflect.DeepEqual(keys,
+		[]string{"0", "2", "4", "6", "8", "10", "1", "3", "5", "7", "9", "11"}) {
+		t.Errorf("unwatched-first gave %v", keys)
+	}
+	if keys := order(t, selection.OrderRecentlyAdded, 1, nil); keys[0] != "11" || keys[11] != "0" {
+		t.Errorf("recently-added gave %v", keys)
+	}
+
+	history := &selection.History{Copied: map[string]time.Time{
+		"0": time.Unix(200, 0),
+		"1": time.Unix(100, 0),
+	}}
+	if keys := order(t, selection.OrderLeastRecentlyCopied, 1, history); keys[9] != "11" || keys[10] != "1" || keys[11] != "0" {
+		t.Errorf("least-recently-copied gave %v", keys)
+	}
+
+	if _, err := selection.Get("alphabetical"); err == nil {
+		t.Error("expected an error for an unknown order")
+	}
+}
+
+func TestNextUnwatched(t *testing.T) {
+	items := structures.NewOrderedMap[models.PlaylistItem]()
+	for _, episode := range []int{5, 1, 4, 2, 3} {
+		watched := 0
+		if episode <= 2 {
+			watched = 1
+		}
+		items.Set(fmt.Sprint("A", episode), models.PlaylistItem{Type: "episode", Parent: "A", Season: 1, Episode: episode, ViewCount: watched})
+	}
+	items.Set("B2", models.PlaylistItem{Type: "episode", Parent: "B", Season: 2, Episode: 1})
+	items.Set("B1", models.PlaylistItem{Type: "episode", Parent: "B", Season: 1, Episode: 9, ViewCount: 1})
+	items.Set("M", models.PlaylistItem{Type: "movie"})
+
+	strategy, err := selection.Get(selection.OrderNextUnwatched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &selection.Env{Playlist: &models.Playlist{EpisodesPerShow: 2}, Random: rand.New(rand.NewSource(1))}
+	ordered := selection.Apply(&items, strategy, env)
+	if keys := ordered.Keys(); !reflect.DeepEqual(keys, []string{"A3", "B2", "A4", "M"}) {
+		t.Errorf("next-unwatched gave %v", keys)
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	items := testPlaylist()
+	existing := make(map[string]uint64)
+	for i := 0; i < 12; i++ {
+		existing[fmt.Sprint(i)] = 1000
+	}
+
+	usage, err := selection.NewUsage([]models.Quota{{By: selection.QuotaShow, Items: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var over []string
+	for _, item := range usage.Trim(items, existing) {
+		over = append(over, item.Keys[0])
+	}
+	if !reflect.DeepEqual(over, []string{"6", "7", "8", "9", "10", "11"}) {
+		t.Errorf("over the show quota were %v", over)
+	}
+	show := models.PlaylistItem{Parent: "Show 0"}
+	if fits, _ := usage.Fits(show, 1000); fits {
+		t.Error("a third episode of a show fit a quota of 2")
+	}
+	usage.Remove(show, 1000)
+	if fits, _ := usage.Fits(show, 1000); !fits {
+		t.Error("an episode didn't fit after one was removed")
+	}
+	if fits, _ := usage.Fits(models.PlaylistItem{Type: "movie"}, 1000); !fits {
+		t.Error("a movie was limited by the show quota")
+	}
+
+	usage, _ = selection.NewUsage([]models.Quota{{By: selection.QuotaGenre, RawSize: "3kB"}})
+	usage.Add(models.PlaylistItem{Genres: []string{"Comedy", "Drama"}}, 2000)
+	if fits, _ := usage.Fits(models.PlaylistItem{Genres: []string{"Drama"}}, 1500); fits {
+		t.Error("a drama fit over the genre size quota")
+	}
+	if fits, _ := usage.Fits(models.PlaylistItem{Genres: []string{"Horror"}}, 1500); !fits {
+		t.Error("a horror movie didn't fit the genre size quota")
+	}
+
+	if _, err := selection.NewUsage([]models.Quota{{By: selection.QuotaShow, RawSize: "20 gigs"}}); err == nil {
+		t.Error("expected an error for a size which can't be parsed")
+	}
+	if _, err := selection.NewUsage([]models.Quota{{By: "studio", Items: 1}}); err == nil {
+		t.Error("expected an error for an unknown quota")
+	}
+}
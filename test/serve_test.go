@@ -0,0 +1,186 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	client "github.com/jrudio/go-plex-client"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"plex-go-sync/internal/actions/serve"
+	"plex-go-sync/internal/models"
+	"testing"
+	"time"
+)
+
+// webhookServers starts a source server which answers for item 5 with the given status, and a destination
+// server with an empty Movies library, so an item which is read finds no match and is done with. It returns
+// the config and the number of times item 5 was read.
+func webhookServers(t *testing.T, status *int) (*models.Config, *int) {
+	reads := 0
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/identity":
+			_, _ = w.Write([]byte(`{"MediaContainer": {"machineIdentifier": "src"}}`))
+		case "/library/sections":
+			_, _ = w.Write([]byte(`{"MediaContainer": {"Directory": [{"key": "1", "title": "Movies"}]}}`))
+		case "/library/metadata/5":
+			reads++
+			w.WriteHeader(*status)
+			_, _ = w.Write([]byte(`{"MediaContainer": {"Metadata": [{"ratingKey": "5", "title": "Film", "type": "movie",
+				"librarySectionTitle": "Movies"}]}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	destination := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/identity":
+			_, _ = w.Write([]byte(`{"MediaContainer": {"machineIdentifier": "dest"}}`))
+		case "/library/sections":
+			_, _ = w.Write([]byte(`{"MediaContainer": {"Directory": [{"key": "1", "title": "Movies"}]}}`))
+		case "/library/sections/1/all":
+			_, _ = w.Write([]byte(`{"MediaContainer": {}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(source.Close)
+	t.Cleanup(destination.Close)
+	return &models.Config{
+		SourceConnection:      models.Connection{URL: source.URL, Token: "token", Retries: -1},
+		DestinationConnection: models.Connection{URL: destination.URL, Token: "token", Retries: -1},
+		Users:                 []models.User{{Name: "Guest", SourceToken: "token", DestinationToken: "token"}},
+	}, &reads
+}
+
+func webhook(server string, ratingKey string, owner bool, account string) client.Webhook {
+	var w client.Webhook
+	w.Event = "media.scrobble"
+	w.Server.UUID = server
+	w.Metadata.RatingKey = ratingKey
+	w.Owner = owner
+	w.Account.Title = account
+	return w
+}
+
+// queuedEvents returns the attempts of each event in a queue file
+func queuedEvents(t *testing.T, path string) []int {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	var events []struct {
+		Attempts int `json:"attempts"`
+	}
+	if err := json.Unmarshal(data, &events); err != nil {
+		t.Fatal(err)
+	}
+	attempts := make([]int, len(events))
+	for i, event := range events {
+		attempts[i] = event.Attempts
+	}
+	return attempts
+}
+
+func TestReceiverQueue(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	config, reads := webhookServers(t, &status)
+	path := filepath.Join(t.TempDir(), "webhook-queue.json")
+	ctx := context.Background()
+
+	// an event is saved before it is synced
+	r := serve.NewReceiver(config, path, 0)
+	r.Receive(webhook("src", "5", true, ""))
+	if got := queuedEvents(t, path); len(got) != 1 || got[0] != 0 {
+		t.Fatalf("got queued attempts %v after receiving, want [0]", got)
+	}
+
+	// it stays saved while the server is unavailable, and is only tried again as a retry
+	r.SyncQueued(&ctx, false)
+	r.SyncQueued(&ctx, false)
+	if got := queuedEvents(t, path); len(got) != 1 || got[0] != 1 || *reads != 1 {
+		t.Fatalf("got queued attempts %v after %d reads, want [1] after 1", got, *reads)
+	}
+
+	// a newer webhook for the same item replaces it
+	r.Receive(webhook("src", "5", true, ""))
+	if got := queuedEvents(t, path); len(got) != 1 || got[0] != 0 {
+		t.Fatalf("got queued attempts %v after receiving again, want [0]", got)
+	}
+
+	// and a restart picks it up again, until it syncs
+	status = http.StatusOK
+	serve.NewReceiver(config, path, 0).SyncQueued(&ctx, true)
+	if got := queuedEvents(t, path); len(got) != 0 || *reads != 2 {
+		t.Errorf("got queued attempts %v after %d reads, want none after 2", got, *reads)
+	}
+}
+
+func TestReceiverFiltering(t *testing.T) {
+	cases := []struct {
+		name  string
+		hook  client.Webhook
+		saved bool
+		reads int
+	}{
+		{"owner", webhook("src", "5", true, ""), true, 1},
+		{"configured user", webhook("src", "5", false, "guest"), true, 1},
+		{"unknown user", webhook("src", "5", false, "stranger"), true, 0},
+		{"unknown server", webhook("other", "5", true, ""), true, 0},
+		{"no item", webhook("src", "", true, ""), false, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			status := http.StatusOK
+			config, reads := webhookServers(t, &status)
+			path := filepath.Join(t.TempDir(), "webhook-queue.json")
+			ctx := context.Background()
+
+			r := serve.NewReceiver(config, path, 0)
+			r.Receive(c.hook)
+			if saved := len(queuedEvents(t, path)) > 0; saved != c.saved {
+				t.Errorf("got saved %v, want %v", saved, c.saved)
+			}
+			r.SyncQueued(&ctx, false)
+			if got := queuedEvents(t, path); len(got) != 0 || *reads != c.reads {
+				t.Errorf("got queued attempts %v after %d reads, want none after %d", got, *reads, c.reads)
+			}
+		})
+	}
+}
+
+func TestReceiverRetry(t *testing.T) {
+	cases := []struct {
+		name   string
+		status int
+		maxAge time.Duration
+		kept   bool
+	}{
+		{"synced", http.StatusOK, 0, false},
+		{"unavailable", http.StatusServiceUnavailable, 0, true},
+		{"unavailable within max age", http.StatusServiceUnavailable, time.Hour, true},
+		{"unavailable past max age", http.StatusServiceUnavailable, time.Nanosecond, false},
+		{"not found", http.StatusNotFound, 0, false},
+		{"unauthorized", http.StatusUnauthorized, 0, false},
+		{"forbidden", http.StatusForbidden, 0, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			status := c.status
+			config, _ := webhookServers(t, &status)
+			path := filepath.Join(t.TempDir(), "webhook-queue.json")
+			ctx := context.Background()
+
+			r := serve.NewReceiver(config, path, c.maxAge)
+			r.Receive(webhook("src", "5", true, ""))
+			r.SyncQueued(&ctx, false)
+			if kept := len(queuedEvents(t, path)) > 0; kept != c.kept {
+				t.Errorf("got kept %v, want %v", kept, c.kept)
+			}
+		})
+	}
+}
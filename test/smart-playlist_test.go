@@ -0,0 +1,29 @@
+package test
+
+import (
+	"plex-go-sync/internal/plex"
+	"testing"
+)
+
+func TestSmartPlaylistQuery(t *testing.T) {
+	cases := []struct {
+		content string
+		want    string
+		ok      bool
+	}{
+		{"library://abc/directory/%2Flibrary%2Fsections%2F1%2Fall%3Ftype%3D4%26unwatched%3D1",
+			"/library/sections/1/all?type=4&unwatched=1", true},
+		{"library://abc/directory//library/sections/2/all?type=1", "/library/sections/2/all?type=1", true},
+		{"library://abc/directory/library%2Fsections%2F3%2Fall", "/library/sections/3/all", true},
+		{"library://abc/item/%2Flibrary%2Fmetadata%2F5", "", false},
+		{"library://abc/directory/", "", false},
+		{"library://abc/directory/%2Flibrary%zz", "", false},
+		{"", "", false},
+	}
+	for _, c := range cases {
+		query, err := plex.SmartPlaylistQuery(c.content)
+		if (err == nil) != c.ok || query != c.want {
+			t.Errorf("%s: got %q %v", c.content, query, err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"plex-go-sync/internal/actions/sync"
+	"plex-go-sync/internal/models"
+	"plex-go-sync/internal/plex"
+	"testing"
+)
+
+func TestConnectUser(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("X-Plex-Token") {
+		case "user":
+			_, _ = w.Write([]byte(`{"MediaContainer": {"Directory": [{"key": "1", "title": "Movies"}]}}`))
+		case "garbled":
+			_, _ = w.Write([]byte(`{"MediaContainer": [`))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	cases := []struct {
+		name  string
+		url   string
+		token string
+		check func(error) bool
+	}{
+		{"user token", server.URL, "user", func(err error) bool { return err == nil }},
+		{"no token", server.URL, "", func(err error) bool { return errors.Is(err, sync.ErrNoToken) }},
+		{"token of another server", server.URL, "admin", plex.IsUnauthorized},
+		{"malformed libraries", server.URL, "garbled", func(err error) bool { return err != nil && !plex.IsUnauthorized(err) }},
+		{"unreachable server", closed.URL, "user", func(err error) bool { return err != nil }},
+		{"malformed url", "http://[::1", "user", func(err error) bool { return err != nil }},
+	}
+	ctx := context.Background()
+	for _, c := range cases {
+		conn := models.Connection{URL: c.url, Token: "admin", Retries: -1}
+		user, err := sync.ConnectUser(&ctx, conn, c.token)
+		if !c.check(err) {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		}
+		if err == nil && user.Token != c.token {
+			t.Errorf("%s: connected with token %s", c.name, user.Token)
+		}
+	}
+}
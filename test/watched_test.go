@@ -0,0 +1,37 @@
+package test
+
+import (
+	"plex-go-sync/internal/plex"
+	"testing"
+)
+
+func TestResolveWatched(t *testing.T) {
+	watchedAtHome := plex.WatchState{Watched: true, LastViewedAt: 100}
+	resumedInCar := plex.WatchState{ViewOffset: 60000, LastViewedAt: 200}
+	// refreshed after the destination copy was watched, but never played
+	refreshedAtHome := plex.WatchState{UpdatedAt: 300}
+	watchedInCar := plex.WatchState{Watched: true, LastViewedAt: 100, UpdatedAt: 50}
+	resumedAtHome := plex.WatchState{ViewOffset: 1000, UpdatedAt: 300}
+	resumedInCarEarlier := plex.WatchState{ViewOffset: 2000, UpdatedAt: 200}
+
+	cases := []struct {
+		policy plex.ConflictPolicy
+		src    plex.WatchState
+		dest   plex.WatchState
+		want   plex.Direction
+	}{
+		{plex.NewestWins, watchedAtHome, watchedAtHome, plex.NoChange},
+		{plex.NewestWins, watchedAtHome, resumedInCar, plex.ToSource},
+		{plex.NewestWins, resumedInCar, watchedAtHome, plex.ToDestination},
+		{plex.NewestWins, refreshedAtHome, watchedInCar, plex.ToSource},
+		{plex.NewestWins, watchedInCar, refreshedAtHome, plex.ToDestination},
+		{plex.NewestWins, resumedAtHome, resumedInCarEarlier, plex.ToDestination},
+		{plex.SourceWins, watchedAtHome, resumedInCar, plex.ToDestination},
+		{plex.DestinationWins, resumedInCar, watchedAtHome, plex.ToSource},
+	}
+	for i, c := range cases {
+		if got := plex.ResolveWatched(c.src, c.dest, c.policy); got != c.want {
+			t.Errorf("case %d (%s): got %d, want %d", i, c.policy, got, c.want)
+		}
+	}
+}